@@ -0,0 +1,94 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"k8s.io/klog/v2"
+
+	"github.com/kubernetes-csi/csi-driver-host-path/internal/backup"
+)
+
+// runBackup implements "hostpathplugin backup", which backs up a block
+// snapshot with the Snapshot Metadata service of a running driver.
+func runBackup(args []string) error {
+	flags := flag.NewFlagSet("backup", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s backup [flags]\n\nCreates a full or, with -base-snapshot-id, an incremental backup of a block snapshot.\n\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	addVerbosityFlag(flags)
+	endpoint := flags.String("endpoint", "unix:///tmp/csi.sock", "CSI endpoint of the driver which serves the Snapshot Metadata service")
+	var opts backup.Options
+	flags.StringVar(&opts.SnapshotID, "snapshot-id", "", "ID of the snapshot which gets backed up")
+	flags.StringVar(&opts.BaseSnapshotID, "base-snapshot-id", "", "ID of an older snapshot of the same volume. Only blocks which changed since then are backed up.")
+	flags.StringVar(&opts.Source, "source", "", "File or block device with the content of the snapshot, for example <statedir>/<snapshot-id>.snap")
+	flags.StringVar(&opts.Output, "output", "", "Backup file which gets created")
+	flags.Parse(args)
+	if flags.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments: %v", flags.Args())
+	}
+	if opts.SnapshotID == "" || opts.Source == "" || opts.Output == "" {
+		return errors.New("-snapshot-id, -source and -output are required")
+	}
+
+	conn, err := backup.Connect(*endpoint)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer cancel()
+	return backup.Backup(ctx, csi.NewSnapshotMetadataClient(conn), opts)
+}
+
+// runRestore implements "hostpathplugin restore", which restores a full
+// backup and a chain of incremental backups to a block volume.
+func runRestore(args []string) error {
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s restore -target <file or device> <full backup> [<incremental backup> ...]\n\nRestores a full backup followed by incremental backups, each one based on the snapshot of the previous backup.\n\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	addVerbosityFlag(flags)
+	target := flags.String("target", "", "File or block device which gets overwritten with the restored data")
+	flags.Parse(args)
+	if *target == "" {
+		return errors.New("-target is required")
+	}
+	if flags.NArg() == 0 {
+		return errors.New("at least one backup file is required")
+	}
+	return backup.Restore(*target, flags.Args())
+}
+
+// addVerbosityFlag adds only the -v flag of klog, the other flags are not
+// useful for the subcommands.
+func addVerbosityFlag(flags *flag.FlagSet) {
+	klogFlags := flag.NewFlagSet("klog", flag.ContinueOnError)
+	klog.InitFlags(klogFlags)
+	v := klogFlags.Lookup("v")
+	flags.Var(v.Value, v.Name, v.Usage)
+}
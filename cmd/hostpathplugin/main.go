@@ -23,7 +23,9 @@ import (
 	"os"
 	"os/signal"
 	"path"
+	"runtime"
 	"syscall"
+	"time"
 
 	"k8s.io/klog/v2"
 
@@ -39,17 +41,40 @@ var (
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		var run func([]string) error
+		switch os.Args[1] {
+		case "backup":
+			run = runBackup
+		case "restore":
+			run = runRestore
+		}
+		if run != nil {
+			if err := run(os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "%s failed: %v\n", os.Args[1], err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
 	cfg := hostpath.Config{
 		VendorVersion: version,
 	}
 
 	flag.StringVar(&cfg.Endpoint, "endpoint", "unix:///tmp/csi.sock", "CSI endpoint")
+	flag.StringVar(&cfg.MetricsAddress, "metrics-address", "", "The TCP network address where the Prometheus metrics endpoint will listen (example: `:8080`). Metrics are disabled when empty.")
 	flag.StringVar(&cfg.DriverName, "drivername", "hostpath.csi.k8s.io", "name of the driver")
 	flag.StringVar(&cfg.StateDir, "statedir", "/csi-data-dir", "directory for storing state information across driver restarts, volumes and snapshots")
 	flag.StringVar(&cfg.NodeID, "nodeid", "", "node id")
+	flag.Var(&cfg.EphemeralTemplateDirs, "ephemeral-template-dirs", "Comma separated list of host directories which may contain templates for ephemeral inline volumes. The templateDir volume attribute must be inside one of them. Templates are disabled when empty.")
 	flag.BoolVar(&cfg.Ephemeral, "ephemeral", false, "publish volumes in ephemeral mode even if kubelet did not ask for it (only needed for Kubernetes 1.15)")
 	flag.Int64Var(&cfg.MaxVolumesPerNode, "maxvolumespernode", 0, "limit of volumes per node")
 	flag.Var(&cfg.Capacity, "capacity", "Simulate storage capacity. The parameter is <kind>=<quantity> where <kind> is the value of a 'kind' storage class parameter and <quantity> is the total amount of bytes for that kind. The flag may be used multiple times to configure different kinds.")
+	flag.Var(&cfg.CapacityDirs, "capacity-dir", "Create the volumes of a kind in a certain directory instead of the state directory. The parameter is <kind>=<directory>, where <kind> must also be configured with -capacity. The free space of the filesystem with the directory then limits the capacity of that kind. The flag may be used multiple times to configure different kinds.")
+	flag.Var(&cfg.CapacityPolicies, "capacity-provisioning", "Provisioning policy of a kind configured with -capacity. The parameter is <kind>=thick or <kind>=thin[,overcommit=<ratio>][,watermark=<percent>]. Thick volumes reserve their whole size. Thin volumes may provision up to <ratio> times the capacity, but no more volumes get created once the data actually stored in them exceeds <percent> of the capacity, or of the space available in the directory of the kind if that is smaller. Defaults to thick.")
+	flag.Var(&cfg.VolumeSizeLimits, "volume-size-limits", "Size limits of the volumes of a kind configured with -capacity. The parameter is <kind>=[min=<quantity>][,max=<quantity>][,granularity=<quantity>]. Requested sizes get rounded up to the minimum and to a multiple of the granularity, within the limit of the request. The flag may be used multiple times to configure different kinds.")
+	flag.Var(&cfg.SnapshotReserves, "snapshot-reserve", "Part of the capacity of a kind configured with -capacity which is reserved for snapshots of its volumes. The parameter is <kind>=<quantity>. Without a reserve, the disk usage of snapshots is charged against the capacity available for volumes. The flag may be used multiple times to configure different kinds.")
 	flag.BoolVar(&cfg.EnableAttach, "enable-attach", false, "Enables RPC_PUBLISH_UNPUBLISH_VOLUME capability.")
 	flag.BoolVar(&cfg.CheckVolumeLifecycle, "check-volume-lifecycle", false, "Can be used to turn some violations of the volume lifecycle into warnings instead of failing the incorrect gRPC call. Disabled by default because of https://github.com/kubernetes/kubernetes/issues/101911.")
 	flag.Int64Var(&cfg.MaxVolumeSize, "max-volume-size", 1024*1024*1024*1024, "maximum size of volumes in bytes (inclusive)")
@@ -60,10 +85,22 @@ func main() {
 	flag.BoolVar(&cfg.EnableControllerModifyVolume, "enable-controller-modify-volume", false, "Enables Controller modify volume feature.")
 	flag.BoolVar(&cfg.EnableSnapshotMetadata, "enable-snapshot-metadata", false, "Enables Snapshot Metadata service.")
 	snapshotMetadataBlockType := flag.String("snapshot-metadata-block-type", "FIXED_LENGTH", "Expected Snapshot Metadata block type in response. Allowed valid types are FIXED_LENGTH or VARIABLE_LENGTH. If not specified, FIXED_LENGTH is used by default.")
+	flag.Int64Var(&cfg.SnapshotMetadataBlockSize, "snapshot-metadata-block-size", 4096, "Default block size in bytes of the Snapshot Metadata service. Must be a power of two, at least 512. Snapshot classes can override it with the blockSize parameter.")
+	flag.Int64Var(&cfg.SnapshotMetadataExtentLimit, "snapshot-metadata-max-extent-length", 0, "Maximum length in bytes of the extents which adjacent blocks get merged into when the block type is VARIABLE_LENGTH. Zero means no limit.")
+	flag.IntVar(&cfg.SnapshotMetadataWorkers, "snapshot-metadata-workers", runtime.NumCPU(), "Number of goroutines which compare snapshot blocks concurrently in the Snapshot Metadata service. One compares blocks sequentially.")
 	flag.Var(&cfg.AcceptedMutableParameterNames, "accepted-mutable-parameter-names", "Comma separated list of parameter names that can be modified on a persistent volume. This is only used when enable-controller-modify-volume is true. If unset, all parameters are mutable.")
 	flag.BoolVar(&cfg.DisableControllerExpansion, "disable-controller-expansion", false, "Disables Controller volume expansion capability.")
 	flag.BoolVar(&cfg.DisableNodeExpansion, "disable-node-expansion", false, "Disables Node volume expansion capability.")
 	flag.BoolVar(&cfg.EnableListSnapshots, "enable-list-snapshots", true, "Enables ControllerServiceCapability_RPC_LIST_SNAPSHOTS capability. Defaults to true.")
+	flag.DurationVar(&cfg.SnapshotScrubInterval, "snapshot-scrub-interval", 0, "Interval at which the checksums of all snapshots are verified. Corrupted snapshots are marked as not ready to use. Zero disables scrubbing.")
+	flag.DurationVar(&cfg.SnapshotGCInterval, "snapshot-gc-interval", 0, "Interval at which snapshot files without a snapshot are removed and the snapshot retention policy is enforced. Zero disables snapshot garbage collection.")
+	flag.DurationVar(&cfg.SnapshotMaxAge, "snapshot-max-age", 0, "Snapshots older than this get deleted by the snapshot garbage collector. Zero means that snapshots do not expire. Members of group snapshots are never deleted.")
+	flag.IntVar(&cfg.SnapshotMaxCount, "snapshot-max-count", 0, "Maximum number of snapshots per source volume. The oldest snapshots get deleted by the snapshot garbage collector. Zero means no limit. Members of group snapshots are not counted.")
+	flag.DurationVar(&cfg.HealthCheckInterval, "health-check-interval", 0, "Interval at which the health of all volumes is checked in the background. ListVolumes, ControllerGetVolume and NodeGetVolumeStats then report the cached volume conditions instead of checking the volume during each call, and condition changes get logged. Zero disables background checks.")
+	flag.DurationVar(&cfg.VolumeUsageMaxAge, "volume-usage-max-age", time.Minute, "NodeGetVolumeStats and the high watermark of thin provisioning determine the usage of filesystem volumes by adding up the space and inodes used by their files. That result gets reused until it is older than this. Zero disables caching.")
+	flag.IntVar(&cfg.GroupSnapshotWorkers, "group-snapshot-workers", 4, "Maximum number of member snapshots that get created in parallel for a group snapshot.")
+	flag.StringVar(&cfg.QuiescePreHook, "group-snapshot-pre-hook", "", "Command which quiesces a filesystem volume before a group snapshot is taken. It is invoked with the volume ID and volume path as additional arguments. Filesystems on block volumes get frozen with fsfreeze instead.")
+	flag.StringVar(&cfg.QuiescePostHook, "group-snapshot-post-hook", "", "Command which resumes a filesystem volume after a group snapshot was taken. It is invoked with the same arguments as group-snapshot-pre-hook, also when taking the group snapshot failed.")
 	flag.Int64Var(&cfg.MaxVolumeExpansionSizeNode, "max-volume-size-node", 0, "Maximum allowed size of volume when expanded on the node. Defaults to same size as max-volume-size.")
 
 	flag.Int64Var(&cfg.AttachLimit, "attach-limit", 0, "Maximum number of attachable volumes on a node. Zero refers to no limit.")
@@ -0,0 +1,229 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package backup creates full and incremental backups of block
+// snapshots with the CSI SnapshotMetadata service and restores them.
+// It exists to test the service end to end, the backup file format is
+// specific to this driver.
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"k8s.io/klog/v2"
+
+	"github.com/kubernetes-csi/csi-driver-host-path/internal/endpoint"
+)
+
+// maxExtentBytes limits how many adjacent blocks get merged into one
+// extent of the backup file.
+const maxExtentBytes = 4 * 1024 * 1024
+
+// Options describe a backup.
+type Options struct {
+	// SnapshotID is the snapshot which gets backed up.
+	SnapshotID string
+	// BaseSnapshotID selects an incremental backup with the blocks
+	// which changed since that snapshot. A full backup with all
+	// allocated blocks is created when empty.
+	BaseSnapshotID string
+	// Source is a file or block device with the content of the
+	// snapshot.
+	Source string
+	// Output is the backup file. It gets replaced atomically.
+	Output string
+}
+
+// Connect creates a gRPC connection to a CSI endpoint like
+// unix:///csi/csi.sock.
+func Connect(ep string) (*grpc.ClientConn, error) {
+	proto, addr, err := endpoint.Parse(ep)
+	if err != nil {
+		return nil, err
+	}
+	dialer := func(ctx context.Context, addr string) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, proto, addr)
+	}
+	return grpc.NewClient("passthrough:///"+addr,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithContextDialer(dialer),
+	)
+}
+
+// Backup asks the SnapshotMetadata service for the allocated or changed
+// blocks of the snapshot and stores their content, read from the
+// snapshot source, in the backup file.
+func Backup(ctx context.Context, client csi.SnapshotMetadataClient, opts Options) (finalErr error) {
+	if opts.SnapshotID == "" {
+		return errors.New("snapshot ID missing")
+	}
+	source, err := os.Open(opts.Source)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	receive, err := metadataStream(ctx, client, opts)
+	if err != nil {
+		return err
+	}
+
+	out, err := os.CreateTemp(filepath.Dir(opts.Output), filepath.Base(opts.Output)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		out.Close()
+		if finalErr != nil {
+			os.Remove(out.Name())
+		}
+	}()
+	w, err := newWriter(out, Header{SnapshotID: opts.SnapshotID, BaseSnapshotID: opts.BaseSnapshotID})
+	if err != nil {
+		return err
+	}
+
+	e := &extentWriter{w: w, source: source}
+	volumeSize := int64(-1)
+	for {
+		capacity, blocks, err := receive()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("get snapshot metadata: %w", err)
+		}
+		volumeSize = capacity
+		for _, block := range blocks {
+			if err := e.add(block.ByteOffset, block.SizeBytes); err != nil {
+				return err
+			}
+		}
+	}
+	if err := e.flush(); err != nil {
+		return err
+	}
+	if volumeSize < 0 {
+		// The service does not send empty responses, so the size of
+		// a volume without any data comes from the source.
+		volumeSize, err = source.Seek(0, io.SeekEnd)
+		if err != nil {
+			return err
+		}
+	}
+	if err := w.finish(volumeSize); err != nil {
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	klog.V(2).Infof("backed up %d bytes in %d extents of snapshot %s to %s", e.bytes, e.extents, opts.SnapshotID, opts.Output)
+	return os.Rename(out.Name(), opts.Output)
+}
+
+// metadataStream starts GetMetadataAllocated for full and
+// GetMetadataDelta for incremental backups.
+func metadataStream(ctx context.Context, client csi.SnapshotMetadataClient, opts Options) (func() (int64, []*csi.BlockMetadata, error), error) {
+	if opts.BaseSnapshotID == "" {
+		stream, err := client.GetMetadataAllocated(ctx, &csi.GetMetadataAllocatedRequest{
+			SnapshotId: opts.SnapshotID,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return func() (int64, []*csi.BlockMetadata, error) {
+			resp, err := stream.Recv()
+			return resp.GetVolumeCapacityBytes(), resp.GetBlockMetadata(), err
+		}, nil
+	}
+
+	stream, err := client.GetMetadataDelta(ctx, &csi.GetMetadataDeltaRequest{
+		BaseSnapshotId:   opts.BaseSnapshotID,
+		TargetSnapshotId: opts.SnapshotID,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return func() (int64, []*csi.BlockMetadata, error) {
+		resp, err := stream.Recv()
+		return resp.GetVolumeCapacityBytes(), resp.GetBlockMetadata(), err
+	}, nil
+}
+
+// extentWriter merges adjacent blocks into extents and writes them with
+// their content.
+type extentWriter struct {
+	w       *writer
+	source  *os.File
+	buffer  []byte
+	offset  int64
+	length  int64
+	extents int
+	bytes   int64
+}
+
+// add appends a block, splitting it when it is longer than
+// maxExtentBytes.
+func (e *extentWriter) add(offset, length int64) error {
+	for length > 0 {
+		chunk := min(length, maxExtentBytes)
+		if e.length > 0 && offset == e.offset+e.length && e.length+chunk <= maxExtentBytes {
+			e.length += chunk
+		} else {
+			if err := e.flush(); err != nil {
+				return err
+			}
+			e.offset, e.length = offset, chunk
+		}
+		offset += chunk
+		length -= chunk
+	}
+	return nil
+}
+
+func (e *extentWriter) flush() error {
+	if e.length == 0 {
+		return nil
+	}
+	if int64(cap(e.buffer)) < e.length {
+		e.buffer = make([]byte, e.length)
+	}
+	n, err := e.source.ReadAt(e.buffer[:e.length], e.offset)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("read snapshot source: %w", err)
+	}
+	e.length = 0
+	// Blocks may extend beyond the end of the source.
+	if n == 0 {
+		return nil
+	}
+	e.extents++
+	e.bytes += int64(n)
+	return e.w.writeExtent(e.offset, e.buffer[:n])
+}
@@ -0,0 +1,273 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backup
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc"
+
+	"github.com/kubernetes-csi/csi-driver-host-path/internal/endpoint"
+)
+
+const (
+	testBlockSize  = 4096
+	testVolumeSize = 32 * testBlockSize
+)
+
+// fakeMetadataServer finds the allocated and changed blocks by
+// comparing the snapshot contents and sends them in small pages.
+type fakeMetadataServer struct {
+	csi.UnimplementedSnapshotMetadataServer
+	snapshots map[string][]byte
+}
+
+func (s *fakeMetadataServer) changedBlocks(base, target []byte) []*csi.BlockMetadata {
+	var blocks []*csi.BlockMetadata
+	for offset := 0; offset < len(target); offset += testBlockSize {
+		if !bytes.Equal(base[offset:offset+testBlockSize], target[offset:offset+testBlockSize]) {
+			blocks = append(blocks, &csi.BlockMetadata{ByteOffset: int64(offset), SizeBytes: testBlockSize})
+		}
+	}
+	return blocks
+}
+
+func (s *fakeMetadataServer) GetMetadataAllocated(req *csi.GetMetadataAllocatedRequest, stream csi.SnapshotMetadata_GetMetadataAllocatedServer) error {
+	blocks := s.changedBlocks(make([]byte, testVolumeSize), s.snapshots[req.SnapshotId])
+	for len(blocks) > 0 {
+		n := min(2, len(blocks))
+		if err := stream.Send(&csi.GetMetadataAllocatedResponse{
+			BlockMetadataType:   csi.BlockMetadataType_FIXED_LENGTH,
+			VolumeCapacityBytes: testVolumeSize,
+			BlockMetadata:       blocks[:n],
+		}); err != nil {
+			return err
+		}
+		blocks = blocks[n:]
+	}
+	return nil
+}
+
+func (s *fakeMetadataServer) GetMetadataDelta(req *csi.GetMetadataDeltaRequest, stream csi.SnapshotMetadata_GetMetadataDeltaServer) error {
+	blocks := s.changedBlocks(s.snapshots[req.BaseSnapshotId], s.snapshots[req.TargetSnapshotId])
+	for len(blocks) > 0 {
+		n := min(2, len(blocks))
+		if err := stream.Send(&csi.GetMetadataDeltaResponse{
+			BlockMetadataType:   csi.BlockMetadataType_FIXED_LENGTH,
+			VolumeCapacityBytes: testVolumeSize,
+			BlockMetadata:       blocks[:n],
+		}); err != nil {
+			return err
+		}
+		blocks = blocks[n:]
+	}
+	return nil
+}
+
+func writeBlock(data []byte, block int, content string) {
+	copy(data[block*testBlockSize:(block+1)*testBlockSize], bytes.Repeat([]byte(content), testBlockSize/len(content)))
+}
+
+func TestBackupRestore(t *testing.T) {
+	tmpdir := t.TempDir()
+
+	snap1 := make([]byte, testVolumeSize)
+	writeBlock(snap1, 0, "a")
+	writeBlock(snap1, 1, "b")
+	writeBlock(snap1, 2, "c")
+	writeBlock(snap1, 20, "d")
+	snap2 := bytes.Clone(snap1)
+	writeBlock(snap2, 1, "e")
+	writeBlock(snap2, 31, "f")
+	snap3 := bytes.Clone(snap2)
+	writeBlock(snap3, 20, "\x00")
+	writeBlock(snap3, 21, "g")
+	snapshots := map[string][]byte{"snap1": snap1, "snap2": snap2, "snap3": snap3}
+	for id, data := range snapshots {
+		if err := os.WriteFile(filepath.Join(tmpdir, id), data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	listener, cleanup, err := endpoint.Listen(filepath.Join(tmpdir, "csi.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+	server := grpc.NewServer()
+	csi.RegisterSnapshotMetadataServer(server, &fakeMetadataServer{snapshots: snapshots})
+	go server.Serve(listener)
+	defer server.Stop()
+
+	conn, err := Connect("unix://" + filepath.Join(tmpdir, "csi.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := csi.NewSnapshotMetadataClient(conn)
+
+	backupPath := func(id string) string {
+		return filepath.Join(tmpdir, id+".backup")
+	}
+	for _, opts := range []Options{
+		{SnapshotID: "snap1"},
+		{SnapshotID: "snap2", BaseSnapshotID: "snap1"},
+		{SnapshotID: "snap3", BaseSnapshotID: "snap2"},
+	} {
+		opts.Source = filepath.Join(tmpdir, opts.SnapshotID)
+		opts.Output = backupPath(opts.SnapshotID)
+		if err := Backup(context.Background(), client, opts); err != nil {
+			t.Fatalf("backup of %s failed: %v", opts.SnapshotID, err)
+		}
+	}
+	full, err := os.Stat(backupPath("snap1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if full.Size() >= testVolumeSize/2 {
+		t.Fatalf("expected a compact full backup, got %d bytes", full.Size())
+	}
+
+	testCases := []struct {
+		name          string
+		backups       []string
+		expected      []byte
+		expectedError string
+	}{
+		{
+			name:     "full",
+			backups:  []string{"snap1"},
+			expected: snap1,
+		},
+		{
+			name:     "full and incremental",
+			backups:  []string{"snap1", "snap2"},
+			expected: snap2,
+		},
+		{
+			name:     "chain",
+			backups:  []string{"snap1", "snap2", "snap3"},
+			expected: snap3,
+		},
+		{
+			name:          "incremental first",
+			backups:       []string{"snap2"},
+			expectedError: "expected a full backup",
+		},
+		{
+			name:          "two full backups",
+			backups:       []string{"snap1", "snap1"},
+			expectedError: "expected an incremental backup",
+		},
+		{
+			name:          "gap in chain",
+			backups:       []string{"snap1", "snap3"},
+			expectedError: "not on the previous snapshot snap1",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			target := filepath.Join(t.TempDir(), "target")
+			garbage := bytes.Repeat([]byte("x"), testVolumeSize)
+			if err := os.WriteFile(target, garbage, 0600); err != nil {
+				t.Fatal(err)
+			}
+			var backups []string
+			for _, id := range tc.backups {
+				backups = append(backups, backupPath(id))
+			}
+
+			err := Restore(target, backups)
+			if tc.expectedError != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.expectedError) {
+					t.Fatalf("expected error %q, got %v", tc.expectedError, err)
+				}
+				data, err := os.ReadFile(target)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(data, garbage) {
+					t.Fatal("target modified by failed restore")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("restore failed: %v", err)
+			}
+			data, err := os.ReadFile(target)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(data, tc.expected) {
+				t.Fatal("restored data does not match the snapshot")
+			}
+		})
+	}
+}
+
+func TestRestoreCorrupted(t *testing.T) {
+	tmpdir := t.TempDir()
+	path := filepath.Join(tmpdir, "backup")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := newWriter(file, Header{SnapshotID: "snap1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.writeExtent(testBlockSize, bytes.Repeat([]byte("a"), testBlockSize)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.finish(testVolumeSize); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	if _, err := Inspect(path); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, corrupted := range map[string][]byte{
+		"modified data": bytes.Replace(data, []byte("aaaa"), []byte("aaab"), 1),
+		"truncated":     data[:len(data)-10],
+		"wrong magic":   append([]byte("X"), data[1:]...),
+	} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "backup")
+			if err := os.WriteFile(path, corrupted, 0600); err != nil {
+				t.Fatal(err)
+			}
+			target := filepath.Join(t.TempDir(), "target")
+			if err := os.WriteFile(target, nil, 0600); err != nil {
+				t.Fatal(err)
+			}
+			if err := Restore(target, []string{path}); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
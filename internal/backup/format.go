@@ -0,0 +1,204 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backup
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"math"
+)
+
+// A backup file starts with a header which identifies the snapshot and,
+// for incremental backups, the base snapshot. The extents of the
+// snapshot follow, each one with its offset, length and data. The end
+// of the extents is marked by a negative offset. The trailer contains
+// the size of the volume and a CRC-32 checksum of everything before it.
+// All integers are big-endian.
+const (
+	fileMagic    = "HPBACKUP"
+	fileVersion  = uint32(1)
+	endOfExtents = int64(-1)
+)
+
+// Header identifies the snapshot stored in a backup file.
+type Header struct {
+	SnapshotID string
+	// BaseSnapshotID is empty for full backups.
+	BaseSnapshotID string
+}
+
+// Incremental returns true for backups which only contain the blocks
+// which changed since the base snapshot.
+func (h Header) Incremental() bool {
+	return h.BaseSnapshotID != ""
+}
+
+type writer struct {
+	out *bufio.Writer
+	crc hash.Hash32
+	w   io.Writer
+}
+
+func newWriter(out io.Writer, header Header) (*writer, error) {
+	w := &writer{
+		out: bufio.NewWriter(out),
+		crc: crc32.NewIEEE(),
+	}
+	w.w = io.MultiWriter(w.out, w.crc)
+	if _, err := io.WriteString(w.w, fileMagic); err != nil {
+		return nil, err
+	}
+	if err := binary.Write(w.w, binary.BigEndian, fileVersion); err != nil {
+		return nil, err
+	}
+	for _, id := range []string{header.SnapshotID, header.BaseSnapshotID} {
+		if err := w.writeString(id); err != nil {
+			return nil, err
+		}
+	}
+	return w, nil
+}
+
+func (w *writer) writeString(s string) error {
+	if len(s) > math.MaxUint16 {
+		return fmt.Errorf("string too long: %d bytes", len(s))
+	}
+	if err := binary.Write(w.w, binary.BigEndian, uint16(len(s))); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w.w, s)
+	return err
+}
+
+func (w *writer) writeExtent(offset int64, data []byte) error {
+	if err := binary.Write(w.w, binary.BigEndian, [2]int64{offset, int64(len(data))}); err != nil {
+		return err
+	}
+	_, err := w.w.Write(data)
+	return err
+}
+
+// finish writes the trailer and flushes the file.
+func (w *writer) finish(volumeSize int64) error {
+	if err := binary.Write(w.w, binary.BigEndian, [2]int64{endOfExtents, volumeSize}); err != nil {
+		return err
+	}
+	if err := binary.Write(w.out, binary.BigEndian, w.crc.Sum32()); err != nil {
+		return err
+	}
+	return w.out.Flush()
+}
+
+type reader struct {
+	crc hash.Hash32
+	r   io.Reader
+	// volumeSize is known once all extents were read.
+	volumeSize int64
+}
+
+func newReader(in io.Reader) (*reader, Header, error) {
+	r := &reader{crc: crc32.NewIEEE()}
+	r.r = io.TeeReader(bufio.NewReader(in), r.crc)
+
+	magic := make([]byte, len(fileMagic))
+	if _, err := io.ReadFull(r.r, magic); err != nil {
+		return nil, Header{}, fmt.Errorf("read header: %w", err)
+	}
+	if string(magic) != fileMagic {
+		return nil, Header{}, errors.New("not a backup file")
+	}
+	var version uint32
+	if err := binary.Read(r.r, binary.BigEndian, &version); err != nil {
+		return nil, Header{}, fmt.Errorf("read header: %w", err)
+	}
+	if version != fileVersion {
+		return nil, Header{}, fmt.Errorf("unsupported backup file version %d", version)
+	}
+	var header Header
+	for _, id := range []*string{&header.SnapshotID, &header.BaseSnapshotID} {
+		s, err := r.readString()
+		if err != nil {
+			return nil, Header{}, fmt.Errorf("read header: %w", err)
+		}
+		*id = s
+	}
+	return r, header, nil
+}
+
+func (r *reader) readString() (string, error) {
+	var length uint16
+	if err := binary.Read(r.r, binary.BigEndian, &length); err != nil {
+		return "", err
+	}
+	s := make([]byte, length)
+	if _, err := io.ReadFull(r.r, s); err != nil {
+		return "", err
+	}
+	return string(s), nil
+}
+
+// next returns the offset and length of the next extent, whose data
+// must be consumed with copyData before calling next again. After the
+// last extent, it verifies the trailer and returns io.EOF.
+func (r *reader) next() (int64, int64, error) {
+	var extent [2]int64
+	if err := binary.Read(r.r, binary.BigEndian, &extent); err != nil {
+		return 0, 0, unexpectedEOF(err)
+	}
+	if extent[0] == endOfExtents {
+		r.volumeSize = extent[1]
+		if err := r.verifyChecksum(); err != nil {
+			return 0, 0, err
+		}
+		return 0, 0, io.EOF
+	}
+	if extent[0] < 0 || extent[1] <= 0 {
+		return 0, 0, fmt.Errorf("invalid extent at offset %d with length %d", extent[0], extent[1])
+	}
+	return extent[0], extent[1], nil
+}
+
+func (r *reader) copyData(dst io.Writer, length int64) error {
+	_, err := io.CopyN(dst, r.r, length)
+	return unexpectedEOF(err)
+}
+
+func (r *reader) verifyChecksum() error {
+	expected := r.crc.Sum32()
+	var checksum uint32
+	if err := binary.Read(r.r, binary.BigEndian, &checksum); err != nil {
+		return unexpectedEOF(err)
+	}
+	if checksum != expected {
+		return fmt.Errorf("checksum mismatch: expected %08x, got %08x", expected, checksum)
+	}
+	return nil
+}
+
+// unexpectedEOF turns the end of the file into an error, because a
+// backup file only ends after its trailer.
+func unexpectedEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
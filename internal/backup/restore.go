@@ -0,0 +1,180 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backup
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"k8s.io/klog/v2"
+)
+
+// Info describes a verified backup file.
+type Info struct {
+	Header
+	VolumeSize int64
+}
+
+// Inspect reads a backup file completely and verifies its checksum.
+func Inspect(path string) (*Info, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	r, header, err := newReader(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for {
+		_, length, err := r.next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err == nil {
+			err = r.copyData(io.Discard, length)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return &Info{Header: header, VolumeSize: r.volumeSize}, nil
+}
+
+// Restore writes a full backup followed by a chain of incremental
+// backups to a file or block device. All backups are verified before
+// the target gets modified.
+func Restore(target string, backups []string) error {
+	if len(backups) == 0 {
+		return errors.New("no backups to restore")
+	}
+	volumeSize := int64(0)
+	var previous *Info
+	for i, path := range backups {
+		info, err := Inspect(path)
+		if err != nil {
+			return err
+		}
+		switch {
+		case i == 0 && info.Incremental():
+			return fmt.Errorf("%s: expected a full backup, got an incremental backup of snapshot %s based on %s", path, info.SnapshotID, info.BaseSnapshotID)
+		case i > 0 && !info.Incremental():
+			return fmt.Errorf("%s: expected an incremental backup, got a full backup of snapshot %s", path, info.SnapshotID)
+		case i > 0 && info.BaseSnapshotID != previous.SnapshotID:
+			return fmt.Errorf("%s: backup of snapshot %s is based on %s, not on the previous snapshot %s", path, info.SnapshotID, info.BaseSnapshotID, previous.SnapshotID)
+		}
+		volumeSize = max(volumeSize, info.VolumeSize)
+		previous = info
+	}
+
+	file, err := os.OpenFile(target, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	regular := fileInfo.Mode().IsRegular()
+	if regular {
+		// Truncating discards the old content, without having to
+		// write zeros into the blocks which are not in the full
+		// backup.
+		if err := file.Truncate(0); err != nil {
+			return err
+		}
+		if err := file.Truncate(volumeSize); err != nil {
+			return err
+		}
+	} else {
+		size, err := file.Seek(0, io.SeekEnd)
+		if err != nil {
+			return err
+		}
+		if size < volumeSize {
+			return fmt.Errorf("%s: size %d is smaller than the volume size %d", target, size, volumeSize)
+		}
+	}
+
+	for i, path := range backups {
+		// Block devices still contain old data where the full backup
+		// has no extents.
+		zeroGaps := i == 0 && !regular
+		if err := apply(file, path, zeroGaps); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return file.Sync()
+}
+
+func apply(target *os.File, path string, zeroGaps bool) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	r, header, err := newReader(file)
+	if err != nil {
+		return err
+	}
+	position := int64(0)
+	extents := 0
+	for {
+		offset, length, err := r.next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if zeroGaps && offset > position {
+			if err := writeZeros(target, position, offset-position); err != nil {
+				return err
+			}
+		}
+		if err := r.copyData(io.NewOffsetWriter(target, offset), length); err != nil {
+			return err
+		}
+		position = max(position, offset+length)
+		extents++
+	}
+	if zeroGaps && r.volumeSize > position {
+		if err := writeZeros(target, position, r.volumeSize-position); err != nil {
+			return err
+		}
+	}
+	klog.V(2).Infof("restored %d extents of snapshot %s from %s", extents, header.SnapshotID, path)
+	return nil
+}
+
+func writeZeros(target *os.File, offset, length int64) error {
+	zeros := make([]byte, min(length, maxExtentBytes))
+	for length > 0 {
+		n, err := target.WriteAt(zeros[:min(length, int64(len(zeros)))], offset)
+		if err != nil {
+			return err
+		}
+		offset += int64(n)
+		length -= int64(n)
+	}
+	return nil
+}
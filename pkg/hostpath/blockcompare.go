@@ -0,0 +1,252 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hostpath
+
+import (
+	"errors"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"golang.org/x/net/context"
+	"golang.org/x/sys/unix"
+	"k8s.io/klog/v2"
+)
+
+// defaultSegmentBytes is the amount of data which one worker compares at
+// a time when comparing blocks in parallel.
+const defaultSegmentBytes = 4 * 1024 * 1024
+
+// blockSource provides the content of a snapshot file. The file is
+// memory-mapped if possible, otherwise it gets read in large chunks.
+type blockSource struct {
+	file *os.File
+	size int64
+	data []byte
+}
+
+func newBlockSource(file *os.File) (*blockSource, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	source := &blockSource{file: file, size: info.Size()}
+	if source.size > 0 {
+		data, err := unix.Mmap(int(file.Fd()), 0, int(source.size), unix.PROT_READ, unix.MAP_SHARED)
+		if err != nil {
+			klog.V(4).Infof("cannot mmap %s, reading it instead: %v", file.Name(), err)
+		} else {
+			source.data = data
+		}
+	}
+	return source, nil
+}
+
+func (s *blockSource) Close() error {
+	if s == nil || s.data == nil {
+		return nil
+	}
+	data := s.data
+	s.data = nil
+	return unix.Munmap(data)
+}
+
+// read returns length bytes at offset, less at the end of the file. The
+// buffer is used when the file is not memory-mapped and must be large
+// enough.
+func (s *blockSource) read(offset, length int64, buffer []byte) ([]byte, error) {
+	if offset >= s.size {
+		return nil, nil
+	}
+	length = min(length, s.size-offset)
+	if s.data != nil {
+		return s.data[offset : offset+length], nil
+	}
+	n, err := s.file.ReadAt(buffer[:length], offset)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	return buffer[:n], nil
+}
+
+// segmentResult contains the indices of the changed blocks in one segment.
+type segmentResult struct {
+	changed []int64
+	// end is set if the segment reaches the end of both files.
+	end bool
+	err error
+}
+
+// getChangedBlockMetadataParallel implements getChangedBlockMetadata by
+// comparing a window of segments concurrently, one segment per worker.
+// Results are assembled in block order, so they are identical to those
+// of the sequential comparison. Changed blocks which do not fit into
+// the current page are kept for the next one.
+func (cb *fileBlockReader) getChangedBlockMetadataParallel(ctx context.Context) ([]*csi.BlockMetadata, error) {
+	if cb.targetSource == nil {
+		if err := cb.openBlockSources(); err != nil {
+			return nil, err
+		}
+	}
+
+	changedBlocks := []*csi.BlockMetadata{}
+	for {
+		for len(cb.pending) > 0 {
+			blockIndex := cb.pending[0]
+			if int32(len(changedBlocks)) >= cb.maxResult {
+				cb.offset = blockIndex * cb.blockSize
+				return changedBlocks, nil
+			}
+			cb.pending = cb.pending[1:]
+			if extendBlock(changedBlocks, cb.blockMetadataType, blockIndex, cb.blockSize, cb.maxExtentLength) {
+				changedBlocks[len(changedBlocks)-1].SizeBytes += cb.blockSize
+				continue
+			}
+			changedBlocks = append(changedBlocks, createBlockMetadata(blockIndex, cb.blockSize))
+		}
+		cb.offset = cb.nextOffset
+		if cb.pendingEOF {
+			klog.V(4).Infof("reached end of the files")
+			return changedBlocks, io.EOF
+		}
+
+		select {
+		case <-ctx.Done():
+			klog.V(4).Infof("handling cancellation signal, closing goroutine")
+			return nil, ctx.Err()
+		default:
+		}
+		if err := cb.compareWindow(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// compareWindow compares the next segments concurrently and queues
+// their changed blocks.
+func (cb *fileBlockReader) compareWindow() error {
+	segmentBlocks := cb.segmentBlocks
+	firstBlock := cb.nextOffset / cb.blockSize
+	results := make([]segmentResult, len(cb.buffers))
+	var wg sync.WaitGroup
+	for i := range results {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = cb.compareSegment(firstBlock+int64(i)*segmentBlocks, segmentBlocks, cb.buffers[i])
+		}()
+	}
+	wg.Wait()
+
+	for i, result := range results {
+		if result.err != nil {
+			return result.err
+		}
+		cb.pending = append(cb.pending, result.changed...)
+		cb.nextOffset = (firstBlock + int64(i+1)*segmentBlocks) * cb.blockSize
+		if result.end {
+			cb.pendingEOF = true
+			break
+		}
+	}
+	return nil
+}
+
+// compareSegment compares the blocks of one segment. When there is no
+// base file, blocks are compared against zeros.
+func (cb *fileBlockReader) compareSegment(firstBlock, blocks int64, buffers [2][]byte) segmentResult {
+	offset := firstBlock * cb.blockSize
+	length := blocks * cb.blockSize
+	target, err := cb.targetSource.read(offset, length, buffers[1])
+	if err != nil {
+		return segmentResult{err: err}
+	}
+	var base []byte
+	if cb.baseSource != nil {
+		base, err = cb.baseSource.read(offset, length, buffers[0])
+		if err != nil {
+			return segmentResult{err: err}
+		}
+	}
+
+	var result segmentResult
+	for i := int64(0); i < blocks; i++ {
+		targetBlock := blockAt(target, i, cb.blockSize)
+		baseBlock := blockAt(base, i, cb.blockSize)
+		if cb.baseSource == nil {
+			if len(targetBlock) == 0 {
+				result.end = true
+				break
+			}
+			baseBlock = cb.zeroBlock
+		} else if len(targetBlock) == 0 && len(baseBlock) == 0 {
+			result.end = true
+			break
+		}
+		if blockChanged(baseBlock, targetBlock) {
+			result.changed = append(result.changed, firstBlock+i)
+		}
+	}
+	return result
+}
+
+// blockAt returns the i-th block of a segment, shorter or empty at the
+// end of the file.
+func blockAt(segment []byte, i, blockSize int64) []byte {
+	start := min(i*blockSize, int64(len(segment)))
+	end := min(start+blockSize, int64(len(segment)))
+	return segment[start:end]
+}
+
+// openBlockSources prepares the parallel comparison, which starts at the
+// block containing the current offset.
+func (cb *fileBlockReader) openBlockSources() error {
+	target, err := newBlockSource(cb.target)
+	if err != nil {
+		return err
+	}
+	cb.targetSource = target
+	if cb.base != nil {
+		base, err := newBlockSource(cb.base)
+		if err != nil {
+			return err
+		}
+		cb.baseSource = base
+	} else {
+		cb.zeroBlock = make([]byte, cb.blockSize)
+	}
+
+	segmentBytes := cb.segmentBytes
+	if segmentBytes == 0 {
+		segmentBytes = defaultSegmentBytes
+	}
+	cb.segmentBlocks = max(1, segmentBytes/cb.blockSize)
+
+	// Buffers are only needed for files which are not memory-mapped.
+	cb.buffers = make([][2][]byte, cb.workers)
+	for i := range cb.buffers {
+		if cb.baseSource != nil && cb.baseSource.data == nil {
+			cb.buffers[i][0] = make([]byte, cb.segmentBlocks*cb.blockSize)
+		}
+		if cb.targetSource.data == nil {
+			cb.buffers[i][1] = make([]byte, cb.segmentBlocks*cb.blockSize)
+		}
+	}
+	cb.nextOffset = cb.offset / cb.blockSize * cb.blockSize
+	return nil
+}
@@ -0,0 +1,290 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hostpath
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/klog/v2"
+	fsutil "k8s.io/kubernetes/pkg/volume/util/fs"
+
+	"github.com/kubernetes-csi/csi-driver-host-path/pkg/state"
+)
+
+// blockVolumeGranularity is the sector size of loop devices. The size
+// of block volumes is always a multiple of it.
+const blockVolumeGranularity = 512
+
+// getVolumeSize rounds up the required size of a new or expanded volume
+// to the size limits of its kind. It returns a gRPC error if the result
+// exceeds the limit of the request or the maximum size of the kind.
+func (hp *hostPath) getVolumeSize(kind string, required, limit int64, accessType state.AccessType) (int64, error) {
+	limits := hp.config.VolumeSizeLimits[kind]
+	size := limits.roundUp(required)
+	if accessType == state.BlockAccess {
+		size = (size + blockVolumeGranularity - 1) / blockVolumeGranularity * blockVolumeGranularity
+	}
+	if limit > 0 && size > limit {
+		return 0, status.Errorf(codes.OutOfRange, "requested capacity %d must be rounded up to %d for %q, which exceeds the limit %d", required, size, kind, limit)
+	}
+	if limits.Max > 0 && size > limits.Max {
+		return 0, status.Errorf(codes.OutOfRange, "requested capacity %d exceeds maximum allowed %d for %q", size, limits.Max, kind)
+	}
+	return size, nil
+}
+
+// getKindDir returns the directory where volumes of a kind get created.
+func (hp *hostPath) getKindDir(kind string) string {
+	if dir, ok := hp.config.CapacityDirs[kind]; ok {
+		return dir
+	}
+	return hp.config.StateDir
+}
+
+// getFreeSpace returns the free space of the filesystem which stores the
+// volumes of a kind. ok is false for kinds without their own directory,
+// their capacity is only simulated.
+func (hp *hostPath) getFreeSpace(kind string) (free int64, ok bool, err error) {
+	dir, ok := hp.config.CapacityDirs[kind]
+	if !ok {
+		return 0, false, nil
+	}
+	available, _, _, _, _, _, err := fsutil.Info(dir)
+	if err != nil {
+		return 0, true, fmt.Errorf("failed to get free space of %s: %v", dir, err)
+	}
+	return available, true, nil
+}
+
+// availableCapacity returns how much more capacity of a kind can be
+// provisioned. For thick provisioning, that is the configured capacity
+// minus the size of the volumes, limited by the free space of the
+// directory of the kind. Thin provisioning allows overcommitting the
+// capacity as long as the real usage stays below the high watermark.
+func (hp *hostPath) availableCapacity(kind string) (int64, error) {
+	quantity := hp.getVolumeCapacity(kind)
+	policy := hp.config.CapacityPolicies[kind]
+	available := policy.provisioningLimit(quantity.Value()) - hp.getAllocatedCapacity(kind)
+	if policy.Thin {
+		usage, limit, err := hp.checkWatermark(kind)
+		if err != nil {
+			return 0, err
+		}
+		if usage > limit {
+			return 0, nil
+		}
+		return available, nil
+	}
+	free, ok, err := hp.getFreeSpace(kind)
+	if err != nil {
+		return 0, err
+	}
+	if ok {
+		available = min(available, free)
+	}
+	return available, nil
+}
+
+// checkCapacity returns a gRPC error if a new volume of a kind does not
+// fit into the remaining capacity.
+func (hp *hostPath) checkCapacity(kind string, cap int64) error {
+	quantity := hp.getVolumeCapacity(kind)
+	policy := hp.config.CapacityPolicies[kind]
+	used := hp.getAllocatedCapacity(kind)
+	if policy.Thin {
+		limit := policy.provisioningLimit(quantity.Value())
+		if used+cap > limit {
+			return status.Errorf(codes.ResourceExhausted, "requested capacity %d exceeds remaining capacity for %q, %s out of %s already provisioned with overcommit ratio %g",
+				cap, kind, resource.NewQuantity(used, resource.BinarySI).String(), resource.NewQuantity(limit, resource.BinarySI).String(), policy.Overcommit)
+		}
+		usage, watermark, err := hp.checkWatermark(kind)
+		if err != nil {
+			return status.Error(codes.Internal, err.Error())
+		}
+		if usage > watermark {
+			return status.Errorf(codes.ResourceExhausted, "real usage %s of %q exceeds the high watermark of %d%% of %s",
+				resource.NewQuantity(usage, resource.BinarySI).String(), kind, policy.HighWatermark, quantity.String())
+		}
+		return nil
+	}
+
+	if used+cap > quantity.Value() {
+		return status.Errorf(codes.ResourceExhausted, "requested capacity %d exceeds remaining capacity for %q, %s out of %s already used",
+			cap, kind, resource.NewQuantity(used, resource.BinarySI).String(), quantity.String())
+	}
+	free, ok, err := hp.getFreeSpace(kind)
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	if ok && cap > free {
+		return status.Errorf(codes.ResourceExhausted, "requested capacity %d exceeds free space %s in %s for %q",
+			cap, resource.NewQuantity(free, resource.BinarySI).String(), hp.config.CapacityDirs[kind], kind)
+	}
+	return nil
+}
+
+// getVolumeCapacity returns the configured capacity of a kind minus its
+// snapshot reserve.
+func (hp *hostPath) getVolumeCapacity(kind string) resource.Quantity {
+	quantity := hp.config.Capacity[kind]
+	if reserve, ok := hp.config.SnapshotReserves[kind]; ok {
+		quantity.Sub(reserve)
+	}
+	return quantity
+}
+
+// getAllocatedCapacity returns the size of all volumes of a kind plus
+// the disk usage of their snapshots, unless those are charged to a
+// snapshot reserve.
+func (hp *hostPath) getAllocatedCapacity(kind string) int64 {
+	allocated := hp.sumVolumeSizes(kind)
+	if _, ok := hp.config.SnapshotReserves[kind]; !ok {
+		allocated += hp.sumSnapshotSizes(kind)
+	}
+	return allocated
+}
+
+// chargeSnapshot records the disk usage of a new snapshot file. It
+// returns a gRPC error if the snapshot does not fit into the snapshot
+// reserve or, without a reserve, into the remaining capacity of the
+// kind of its source volume.
+func (hp *hostPath) chargeSnapshot(snapshot *state.Snapshot, kind string) error {
+	usage, err := allocatedBytes(snapshot.Path)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to get disk usage of snapshot %s: %v", snapshot.Path, err)
+	}
+	snapshot.Kind = kind
+	snapshot.DiskUsage = usage
+	if !hp.config.Capacity.Enabled() || kind == "" {
+		return nil
+	}
+
+	if reserve, ok := hp.config.SnapshotReserves[kind]; ok {
+		used := hp.sumSnapshotSizes(kind)
+		if used+usage > reserve.Value() {
+			return status.Errorf(codes.ResourceExhausted, "snapshot of %d bytes exceeds remaining snapshot reserve for %q, %s out of %s already used",
+				usage, kind, resource.NewQuantity(used, resource.BinarySI).String(), reserve.String())
+		}
+		return nil
+	}
+	available, err := hp.availableCapacity(kind)
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	if usage > available {
+		return status.Errorf(codes.ResourceExhausted, "snapshot of %d bytes exceeds remaining capacity %s for %q",
+			usage, resource.NewQuantity(max(available, 0), resource.BinarySI).String(), kind)
+	}
+	return nil
+}
+
+// checkWatermark returns the real usage of the thin volumes of a kind,
+// including snapshots without a reserve, and the high watermark. Without a watermark, the usage is not
+// determined and the limit cannot be exceeded.
+//
+// Extractions of snapshots which are shared by several volumes only
+// count once. The watermark applies to the configured capacity or, for
+// a kind with its own directory, to the space which its volumes use
+// there plus the free space, whatever is smaller.
+func (hp *hostPath) checkWatermark(kind string) (usage, limit int64, err error) {
+	policy := hp.config.CapacityPolicies[kind]
+	if policy.HighWatermark == 0 {
+		return 0, 0, nil
+	}
+	if _, ok := hp.config.SnapshotReserves[kind]; !ok {
+		usage = hp.sumSnapshotSizes(kind)
+	}
+	var volumesUsage int64
+	extractions := map[string]bool{}
+	for _, vol := range hp.state.GetVolumes() {
+		if vol.Kind != kind {
+			continue
+		}
+		switch {
+		case vol.OverlayLowerDir != "":
+			extractions[vol.OverlayLowerDir] = true
+			upper, err := hp.getPathUsage(hp.getOverlayPath(vol.VolID))
+			if err != nil {
+				return 0, 0, err
+			}
+			usage += upper
+		case vol.SharedSnapshotDir != "":
+			extractions[vol.SharedSnapshotDir] = true
+		default:
+			volumeUsage, err := hp.getVolumeUsage(vol)
+			if err != nil {
+				return 0, 0, err
+			}
+			volumesUsage += volumeUsage
+		}
+	}
+	usage += volumesUsage
+	for dir := range extractions {
+		extractionUsage, err := hp.getPathUsage(dir)
+		if err != nil {
+			return 0, 0, err
+		}
+		usage += extractionUsage
+	}
+
+	quantity := hp.getVolumeCapacity(kind)
+	capacity := quantity.Value()
+	free, ok, err := hp.getFreeSpace(kind)
+	if err != nil {
+		return 0, 0, err
+	}
+	if ok {
+		capacity = min(capacity, volumesUsage+free)
+	}
+	limit = capacity * int64(policy.HighWatermark) / 100
+	klog.V(5).Infof("real usage of kind %q: %d bytes, high watermark %d bytes", kind, usage, limit)
+	return usage, limit, nil
+}
+
+// getVolumeUsage returns the disk space which is really allocated for a
+// volume: the blocks of all files in a directory volume or of the file
+// which backs a block volume. The usage of directory volumes comes from
+// the usage cache because walking all of them for each request would be
+// too slow.
+func (hp *hostPath) getVolumeUsage(vol state.Volume) (int64, error) {
+	if vol.VolAccessType == state.BlockAccess {
+		return allocatedBytes(vol.VolPath)
+	}
+	usage, err := hp.getDirectoryUsage(vol)
+	if err != nil {
+		return 0, err
+	}
+	return usage.Bytes, nil
+}
+
+func allocatedBytes(path string) (int64, error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return 0, err
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return info.Size(), nil
+	}
+	// st_blocks is always in units of 512 bytes.
+	return stat.Blocks * 512, nil
+}
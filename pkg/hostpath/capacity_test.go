@@ -0,0 +1,535 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hostpath
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/api/resource"
+	fs "k8s.io/kubernetes/pkg/volume/util/fs"
+
+	"github.com/kubernetes-csi/csi-driver-host-path/pkg/state"
+)
+
+func newCapacityTestDriver(t *testing.T, capacity Capacity, dirs CapacityDirs) *hostPath {
+	hp, err := NewHostPathDriver(Config{
+		StateDir:      t.TempDir(),
+		Endpoint:      "unix://tmp/csi.sock",
+		DriverName:    "hostpath.csi.k8s.io",
+		NodeID:        "fakeNodeID",
+		MaxVolumeSize: 1 << 62,
+		Capacity:      capacity,
+		CapacityDirs:  dirs,
+	})
+	require.NoError(t, err)
+	return hp
+}
+
+func mountVolumeRequest(name, kind string, size int64) *csi.CreateVolumeRequest {
+	return &csi.CreateVolumeRequest{
+		Name: name,
+		VolumeCapabilities: []*csi.VolumeCapability{{
+			AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+			AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+		}},
+		CapacityRange: &csi.CapacityRange{RequiredBytes: size},
+		Parameters:    map[string]string{storageKind: kind},
+	}
+}
+
+func TestCapacityDirs(t *testing.T) {
+	fastDir := filepath.Join(t.TempDir(), "fast")
+	hp := newCapacityTestDriver(t,
+		Capacity{
+			"fast": resource.MustParse("1Ei"),
+			"slow": resource.MustParse("1Gi"),
+		},
+		CapacityDirs{"fast": fastDir},
+	)
+
+	// The configured capacity of "fast" is larger than any filesystem,
+	// so the free space of its directory is the limit.
+	free, _, _, _, _, _, err := fs.Info(fastDir)
+	require.NoError(t, err)
+	resp, err := hp.GetCapacity(context.Background(), &csi.GetCapacityRequest{
+		Parameters: map[string]string{storageKind: "fast"},
+	})
+	require.NoError(t, err)
+	assert.Positive(t, resp.AvailableCapacity)
+	assert.InDelta(t, free, resp.AvailableCapacity, float64(64*mib))
+	assert.Equal(t, resp.AvailableCapacity, resp.MaximumVolumeSize.Value)
+
+	resp, err = hp.GetCapacity(context.Background(), &csi.GetCapacityRequest{
+		Parameters: map[string]string{storageKind: "slow"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1024*mib), resp.AvailableCapacity)
+
+	// Volumes are created in the directory of their kind.
+	vol, err := hp.CreateVolume(context.Background(), mountVolumeRequest("fast-volume", "fast", mib))
+	require.NoError(t, err)
+	fastVolume, err := hp.state.GetVolumeByID(vol.Volume.VolumeId)
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(fastDir, fastVolume.VolID), fastVolume.VolPath)
+	assert.DirExists(t, fastVolume.VolPath)
+	assert.Equal(t, fastVolume.VolPath, hp.getVolumePath(fastVolume.VolID))
+
+	vol, err = hp.CreateVolume(context.Background(), mountVolumeRequest("slow-volume", "slow", mib))
+	require.NoError(t, err)
+	slowVolume, err := hp.state.GetVolumeByID(vol.Volume.VolumeId)
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(hp.config.StateDir, slowVolume.VolID), slowVolume.VolPath)
+
+	// Volumes larger than the free space get rejected, even though
+	// the configured capacity would be sufficient.
+	_, err = hp.CreateVolume(context.Background(), mountVolumeRequest("huge-volume", "fast", 1<<61))
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err), "unexpected error: %v", err)
+
+	_, err = hp.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: fastVolume.VolID})
+	require.NoError(t, err)
+	assert.NoDirExists(t, fastVolume.VolPath)
+}
+
+func TestCapacityDirsWithoutCapacity(t *testing.T) {
+	_, err := NewHostPathDriver(Config{
+		StateDir:     t.TempDir(),
+		Endpoint:     "unix://tmp/csi.sock",
+		DriverName:   "hostpath.csi.k8s.io",
+		NodeID:       "fakeNodeID",
+		Capacity:     Capacity{"slow": resource.MustParse("1Gi")},
+		CapacityDirs: CapacityDirs{"fast": t.TempDir()},
+	})
+	assert.ErrorContains(t, err, `kind "fast" without capacity`)
+}
+
+func TestCapacityPoliciesSet(t *testing.T) {
+	testCases := []struct {
+		arg         string
+		want        CapacityPolicy
+		expectedErr bool
+	}{
+		{arg: "slow=thick", want: CapacityPolicy{}},
+		{arg: "fast=thin", want: CapacityPolicy{Thin: true, Overcommit: 1}},
+		{arg: "fast=thin,overcommit=2.5", want: CapacityPolicy{Thin: true, Overcommit: 2.5}},
+		{arg: "fast=thin,watermark=80,overcommit=3", want: CapacityPolicy{Thin: true, Overcommit: 3, HighWatermark: 80}},
+		{arg: "fast", expectedErr: true},
+		{arg: "fast=thick,overcommit=2", expectedErr: true},
+		{arg: "fast=thin,overcommit=0.5", expectedErr: true},
+		{arg: "fast=thin,watermark=101", expectedErr: true},
+		{arg: "fast=thin,foo=1", expectedErr: true},
+		{arg: "fast=thinner", expectedErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.arg, func(t *testing.T) {
+			var policies CapacityPolicies
+			err := policies.Set(tc.arg)
+			if tc.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			kind, _, _ := strings.Cut(tc.arg, "=")
+			assert.Equal(t, CapacityPolicies{kind: tc.want}, policies)
+		})
+	}
+}
+
+func TestThinProvisioning(t *testing.T) {
+	hp, err := NewHostPathDriver(Config{
+		StateDir:      t.TempDir(),
+		Endpoint:      "unix://tmp/csi.sock",
+		DriverName:    "hostpath.csi.k8s.io",
+		NodeID:        "fakeNodeID",
+		MaxVolumeSize: 1 << 40,
+		Capacity: Capacity{
+			"thin":  resource.MustParse("10Mi"),
+			"thick": resource.MustParse("10Mi"),
+		},
+		CapacityPolicies: CapacityPolicies{
+			"thin":  {Thin: true, Overcommit: 3, HighWatermark: 50},
+			"thick": {},
+		},
+	})
+	require.NoError(t, err)
+	getCapacity := func(kind string) int64 {
+		resp, err := hp.GetCapacity(context.Background(), &csi.GetCapacityRequest{
+			Parameters: map[string]string{storageKind: kind},
+		})
+		require.NoError(t, err)
+		return resp.AvailableCapacity
+	}
+
+	// Thin volumes may be larger than the capacity, up to the
+	// overcommit ratio.
+	assert.Equal(t, 30*mib, getCapacity("thin"))
+	resp, err := hp.CreateVolume(context.Background(), mountVolumeRequest("thin-1", "thin", 12*mib))
+	require.NoError(t, err)
+	thinVolume, err := hp.state.GetVolumeByID(resp.Volume.VolumeId)
+	require.NoError(t, err)
+	_, err = hp.CreateVolume(context.Background(), mountVolumeRequest("thin-2", "thin", 12*mib))
+	require.NoError(t, err)
+	assert.Equal(t, 6*mib, getCapacity("thin"))
+	_, err = hp.CreateVolume(context.Background(), mountVolumeRequest("thin-3", "thin", 12*mib))
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err), "unexpected error: %v", err)
+
+	// Writing data beyond the watermark stops provisioning, even
+	// though there is still room for overcommitment.
+	_, err = hp.CreateVolume(context.Background(), mountVolumeRequest("thin-4", "thin", 4*mib))
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(thinVolume.VolPath, "data"), bytes.Repeat([]byte("x"), int(6*mib)), 0644))
+	assert.Equal(t, int64(0), getCapacity("thin"))
+	_, err = hp.CreateVolume(context.Background(), mountVolumeRequest("thin-5", "thin", mib))
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err), "unexpected error: %v", err)
+	assert.ErrorContains(t, err, "high watermark")
+
+	// Thick volumes reserve their size.
+	_, err = hp.CreateVolume(context.Background(), mountVolumeRequest("thick-1", "thick", 12*mib))
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err), "unexpected error: %v", err)
+	_, err = hp.CreateVolume(context.Background(), mountVolumeRequest("thick-2", "thick", 8*mib))
+	require.NoError(t, err)
+	assert.Equal(t, 2*mib, getCapacity("thick"))
+}
+
+func TestThinBlockVolumeWatermark(t *testing.T) {
+	hp, err := NewHostPathDriver(Config{
+		StateDir:      t.TempDir(),
+		Endpoint:      "unix://tmp/csi.sock",
+		DriverName:    "hostpath.csi.k8s.io",
+		NodeID:        "fakeNodeID",
+		MaxVolumeSize: 1 << 40,
+		Capacity: Capacity{
+			"thin":  resource.MustParse("20Mi"),
+			"thick": resource.MustParse("20Mi"),
+		},
+		CapacityPolicies: CapacityPolicies{
+			"thin":  {Thin: true, Overcommit: 1, HighWatermark: 50},
+			"thick": {HighWatermark: 50},
+		},
+	})
+	require.NoError(t, err)
+
+	// Block volumes cannot be created without loop devices, so only
+	// their backing files get created here.
+	for _, kind := range []string{"thin", "thick"} {
+		vol := state.Volume{
+			VolID:         kind,
+			VolName:       kind,
+			VolSize:       16 * mib,
+			VolPath:       filepath.Join(hp.config.StateDir, kind),
+			VolAccessType: state.BlockAccess,
+			Kind:          kind,
+		}
+		require.NoError(t, createBlockFile(vol.VolPath, vol.VolSize, hp.config.CapacityPolicies[kind].Thin))
+		require.NoError(t, hp.state.UpdateVolume(vol))
+		info, err := os.Stat(vol.VolPath)
+		require.NoError(t, err)
+		assert.Equal(t, vol.VolSize, info.Size(), "size of %s backing file", kind)
+	}
+
+	usage, limit, err := hp.checkWatermark("thin")
+	require.NoError(t, err)
+	assert.Less(t, usage, limit, "sparse thin block volume must stay below the watermark")
+	usage, limit, err = hp.checkWatermark("thick")
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, usage, 16*mib, "thick block volume must be fully allocated")
+	assert.Greater(t, usage, limit)
+}
+
+func TestWatermarkUsesUsageCache(t *testing.T) {
+	hp, err := NewHostPathDriver(Config{
+		StateDir:          t.TempDir(),
+		Endpoint:          "unix://tmp/csi.sock",
+		DriverName:        "hostpath.csi.k8s.io",
+		NodeID:            "fakeNodeID",
+		MaxVolumeSize:     1 << 40,
+		VolumeUsageMaxAge: time.Hour,
+		Capacity:          Capacity{"thin": resource.MustParse("10Mi")},
+		CapacityPolicies:  CapacityPolicies{"thin": {Thin: true, Overcommit: 1, HighWatermark: 50}},
+	})
+	require.NoError(t, err)
+	resp, err := hp.CreateVolume(context.Background(), mountVolumeRequest("thin", "thin", mib))
+	require.NoError(t, err)
+	vol, err := hp.state.GetVolumeByID(resp.Volume.VolumeId)
+	require.NoError(t, err)
+
+	before, _, err := hp.checkWatermark("thin")
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(vol.VolPath, "data"), bytes.Repeat([]byte("x"), int(mib)), 0644))
+	cached, _, err := hp.checkWatermark("thin")
+	require.NoError(t, err)
+	assert.Equal(t, before, cached, "usage must be served from the cache")
+
+	hp.usage.forget(vol.VolID)
+	after, _, err := hp.checkWatermark("thin")
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, after, before+mib)
+}
+
+func TestWatermarkSharedExtractions(t *testing.T) {
+	hp, err := NewHostPathDriver(Config{
+		StateDir:         t.TempDir(),
+		Endpoint:         "unix://tmp/csi.sock",
+		DriverName:       "hostpath.csi.k8s.io",
+		NodeID:           "fakeNodeID",
+		MaxVolumeSize:    1 << 40,
+		Capacity:         Capacity{"thin": resource.MustParse("20Mi")},
+		CapacityPolicies: CapacityPolicies{"thin": {Thin: true, Overcommit: 3, HighWatermark: 50}},
+	})
+	require.NoError(t, err)
+
+	// Three volumes use each extraction, which would exceed the
+	// watermark if it was counted for each of them.
+	lower := hp.getOverlayLowerPath("snap-1")
+	shared := hp.getSharedSnapshotPath("snap-2")
+	for _, dir := range []string{lower, shared} {
+		require.NoError(t, os.Mkdir(dir, 0750))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "data"), bytes.Repeat([]byte("x"), int(2*mib)), 0644))
+	}
+	for _, volID := range []string{"clone-1", "clone-2", "clone-3"} {
+		require.NoError(t, os.MkdirAll(filepath.Join(hp.getOverlayPath(volID), "upper"), 0750))
+		require.NoError(t, hp.state.UpdateVolume(state.Volume{VolID: volID, VolName: volID, Kind: "thin", OverlayLowerDir: lower}))
+	}
+	for _, volID := range []string{"reader-1", "reader-2", "reader-3"} {
+		require.NoError(t, hp.state.UpdateVolume(state.Volume{VolID: volID, VolName: volID, Kind: "thin", SharedSnapshotDir: shared}))
+	}
+	// The changes of a clone count for that clone only.
+	require.NoError(t, os.WriteFile(filepath.Join(hp.getOverlayPath("clone-1"), "upper", "data"), bytes.Repeat([]byte("x"), int(mib)), 0644))
+
+	usage, limit, err := hp.checkWatermark("thin")
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, usage, 5*mib)
+	assert.Less(t, usage, 6*mib)
+	assert.Equal(t, 10*mib, limit)
+}
+
+func TestWatermarkFreeSpace(t *testing.T) {
+	thinDir := filepath.Join(t.TempDir(), "thin")
+	hp, err := NewHostPathDriver(Config{
+		StateDir:         t.TempDir(),
+		Endpoint:         "unix://tmp/csi.sock",
+		DriverName:       "hostpath.csi.k8s.io",
+		NodeID:           "fakeNodeID",
+		MaxVolumeSize:    1 << 62,
+		Capacity:         Capacity{"thin": resource.MustParse("1Ei")},
+		CapacityDirs:     CapacityDirs{"thin": thinDir},
+		CapacityPolicies: CapacityPolicies{"thin": {Thin: true, Overcommit: 1, HighWatermark: 50}},
+	})
+	require.NoError(t, err)
+	resp, err := hp.CreateVolume(context.Background(), mountVolumeRequest("thin", "thin", mib))
+	require.NoError(t, err)
+	vol, err := hp.state.GetVolumeByID(resp.Volume.VolumeId)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(vol.VolPath, "data"), bytes.Repeat([]byte("x"), int(mib)), 0644))
+
+	// The configured capacity of "thin" is larger than any filesystem,
+	// so the watermark applies to what really fits into its directory.
+	usage, limit, err := hp.checkWatermark("thin")
+	require.NoError(t, err)
+	free, _, _, _, _, _, err := fs.Info(thinDir)
+	require.NoError(t, err)
+	assert.InDelta(t, (usage+free)/2, limit, float64(64*mib))
+}
+
+func TestVolumeSizeLimitsSet(t *testing.T) {
+	testCases := []struct {
+		arg         string
+		want        SizeLimits
+		expectedErr bool
+	}{
+		{arg: "fast=min=1Gi", want: SizeLimits{Min: gib}},
+		{arg: "fast=min=1Mi,max=1Gi,granularity=4Mi", want: SizeLimits{Min: mib, Max: gib, Granularity: 4 * mib}},
+		{arg: "fast=granularity=1G", want: SizeLimits{Granularity: 1000 * 1000 * 1000}},
+		{arg: "fast", expectedErr: true},
+		{arg: "fast=min", expectedErr: true},
+		{arg: "fast=min=-1", expectedErr: true},
+		{arg: "fast=size=1Gi", expectedErr: true},
+		{arg: "fast=min=2Gi,max=1Gi", expectedErr: true},
+		{arg: "fast=max=1Gi,granularity=3Gi", expectedErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.arg, func(t *testing.T) {
+			var limits VolumeSizeLimits
+			err := limits.Set(tc.arg)
+			if tc.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, VolumeSizeLimits{"fast": tc.want}, limits)
+		})
+	}
+}
+
+func TestVolumeSizeLimits(t *testing.T) {
+	hp, err := NewHostPathDriver(Config{
+		StateDir:              t.TempDir(),
+		Endpoint:              "unix://tmp/csi.sock",
+		DriverName:            "hostpath.csi.k8s.io",
+		NodeID:                "fakeNodeID",
+		MaxVolumeSize:         1 << 40,
+		EnableVolumeExpansion: true,
+		Capacity: Capacity{
+			"fast": resource.MustParse("10Gi"),
+			"slow": resource.MustParse("10Gi"),
+		},
+		VolumeSizeLimits: VolumeSizeLimits{
+			"fast": {Min: mib, Max: gib, Granularity: 4 * mib},
+		},
+	})
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name         string
+		kind         string
+		required     int64
+		limit        int64
+		expectedSize int64
+		expectedCode codes.Code
+	}{
+		{name: "minimum", kind: "fast", required: 1, expectedSize: 4 * mib},
+		{name: "granularity", kind: "fast", required: 5 * mib, limit: 8 * mib, expectedSize: 8 * mib},
+		{name: "exact", kind: "fast", required: 12 * mib, limit: 12 * mib, expectedSize: 12 * mib},
+		{name: "limit too small", kind: "fast", required: 5 * mib, limit: 6 * mib, expectedCode: codes.OutOfRange},
+		{name: "maximum", kind: "fast", required: 2 * gib, expectedCode: codes.OutOfRange},
+		{name: "no limits", kind: "slow", required: 5*mib + 1, expectedSize: 5*mib + 1},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := mountVolumeRequest(tc.name, tc.kind, tc.required)
+			req.CapacityRange.LimitBytes = tc.limit
+			resp, err := hp.CreateVolume(context.Background(), req)
+			if tc.expectedCode != codes.OK {
+				assert.Equal(t, tc.expectedCode, status.Code(err), "unexpected error: %v", err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedSize, resp.Volume.CapacityBytes)
+			vol, err := hp.state.GetVolumeByID(resp.Volume.VolumeId)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedSize, vol.VolSize)
+		})
+	}
+
+	resp, err := hp.GetCapacity(context.Background(), &csi.GetCapacityRequest{
+		Parameters: map[string]string{storageKind: "fast"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 4*mib, resp.MinimumVolumeSize.Value)
+	assert.Equal(t, gib, resp.MaximumVolumeSize.Value)
+
+	vol, err := hp.state.GetVolumeByName("minimum")
+	require.NoError(t, err)
+	expanded, err := hp.ControllerExpandVolume(context.Background(), &csi.ControllerExpandVolumeRequest{
+		VolumeId:      vol.VolID,
+		CapacityRange: &csi.CapacityRange{RequiredBytes: 9 * mib},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 12*mib, expanded.CapacityBytes)
+
+	// Block volumes consist of whole sectors.
+	size, err := hp.getVolumeSize("", 1000, 0, state.BlockAccess)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1024), size)
+}
+
+func TestSnapshotCapacity(t *testing.T) {
+	hp, err := NewHostPathDriver(Config{
+		StateDir:      t.TempDir(),
+		Endpoint:      "unix://tmp/csi.sock",
+		DriverName:    "hostpath.csi.k8s.io",
+		NodeID:        "fakeNodeID",
+		MaxVolumeSize: 1 << 40,
+		Capacity: Capacity{
+			"reserved": resource.MustParse("10Mi"),
+			"shared":   resource.MustParse("10Mi"),
+		},
+		SnapshotReserves: Capacity{
+			"reserved": resource.MustParse("1Mi"),
+		},
+	})
+	require.NoError(t, err)
+	getCapacity := func(kind string) int64 {
+		resp, err := hp.GetCapacity(context.Background(), &csi.GetCapacityRequest{
+			Parameters: map[string]string{storageKind: kind},
+		})
+		require.NoError(t, err)
+		return resp.AvailableCapacity
+	}
+	createVolume := func(kind string, data int) string {
+		resp, err := hp.CreateVolume(context.Background(), mountVolumeRequest(kind+"-volume", kind, 4*mib))
+		require.NoError(t, err)
+		vol, err := hp.state.GetVolumeByID(resp.Volume.VolumeId)
+		require.NoError(t, err)
+		// Random data does not get compressed in the snapshot.
+		content := make([]byte, data)
+		_, err = rand.Read(content)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(filepath.Join(vol.VolPath, "data"), content, 0644))
+		return vol.VolID
+	}
+	createSnapshot := func(name, volID string) (*state.Snapshot, error) {
+		resp, err := hp.CreateSnapshot(context.Background(), &csi.CreateSnapshotRequest{
+			Name:           name,
+			SourceVolumeId: volID,
+		})
+		if err != nil {
+			return nil, err
+		}
+		snapshot, err := hp.state.GetSnapshotByID(resp.Snapshot.SnapshotId)
+		require.NoError(t, err)
+		return &snapshot, nil
+	}
+
+	// The reserve is not available for volumes.
+	assert.Equal(t, 9*mib, getCapacity("reserved"))
+	volID := createVolume("reserved", 600*int(kib))
+	assert.Equal(t, 5*mib, getCapacity("reserved"))
+	snapshot, err := createSnapshot("reserved-1", volID)
+	require.NoError(t, err)
+	assert.Equal(t, "reserved", snapshot.Kind)
+	assert.Greater(t, snapshot.DiskUsage, 600*kib)
+	assert.Equal(t, 5*mib, getCapacity("reserved"), "snapshots must be charged to the reserve")
+	_, err = createSnapshot("reserved-2", volID)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err), "unexpected error: %v", err)
+	assert.Len(t, hp.state.GetSnapshots(), 1)
+	files, err := filepath.Glob(filepath.Join(hp.config.StateDir, "*"+snapshotExt))
+	require.NoError(t, err)
+	assert.Len(t, files, 1, "snapshot file of the failed snapshot must be removed")
+
+	// Without a reserve, snapshots reduce the capacity for volumes.
+	volID = createVolume("shared", 2*int(mib))
+	assert.Equal(t, 6*mib, getCapacity("shared"))
+	snapshot, err = createSnapshot("shared-1", volID)
+	require.NoError(t, err)
+	assert.Equal(t, 6*mib-snapshot.DiskUsage, getCapacity("shared"))
+	_, err = createSnapshot("shared-2", volID)
+	require.NoError(t, err)
+	_, err = createSnapshot("shared-3", volID)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err), "unexpected error: %v", err)
+}
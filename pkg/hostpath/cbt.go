@@ -0,0 +1,273 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hostpath
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"k8s.io/klog/v2"
+
+	"github.com/kubernetes-csi/csi-driver-host-path/pkg/state"
+)
+
+// changedBlocksExt is the extension of the changed block bitmap files
+// which are stored next to the snapshot files of block volumes.
+const changedBlocksExt = ".cbt"
+
+// changedBlocks is a bitmap with one bit per block, in LSB first order.
+type changedBlocks []byte
+
+func (c *changedBlocks) set(blockIndex int64) {
+	for int64(len(*c)) <= blockIndex/8 {
+		*c = append(*c, 0)
+	}
+	(*c)[blockIndex/8] |= 1 << (blockIndex % 8)
+}
+
+func (c changedBlocks) isSet(blockIndex int64) bool {
+	return blockIndex/8 < int64(len(c)) && c[blockIndex/8]&(1<<(blockIndex%8)) != 0
+}
+
+func (c *changedBlocks) or(other changedBlocks) {
+	for len(*c) < len(other) {
+		*c = append(*c, 0)
+	}
+	for i, b := range other {
+		(*c)[i] |= b
+	}
+}
+
+// blocks returns the number of blocks covered by the bitmap.
+func (c changedBlocks) blocks() int64 {
+	return int64(len(c)) * 8
+}
+
+func (hp *hostPath) getChangedBlocksPath(snapshotID string) string {
+	return filepath.Join(hp.config.StateDir, snapshotID+changedBlocksExt)
+}
+
+// computeChangedBlocks compares two snapshot files block by block, with
+// the given number of workers. Tests replace it.
+var computeChangedBlocks = func(ctx context.Context, basePath, targetPath string, blockSize int64, workers int) (changedBlocks, error) {
+	br, err := newFileBlockReader(basePath, targetPath, 0, blockSize, csi.BlockMetadataType_FIXED_LENGTH, defaultMaxResults)
+	if err != nil {
+		return nil, err
+	}
+	defer br.Close()
+	br.workers = workers
+
+	var bitmap changedBlocks
+	for {
+		cb, err := br.getChangedBlockMetadata(ctx)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+		for _, block := range cb {
+			bitmap.set(block.ByteOffset / blockSize)
+		}
+		if errors.Is(err, io.EOF) {
+			return bitmap, nil
+		}
+	}
+}
+
+// trackChangedBlocks links a new snapshot of a block volume to the most
+// recent snapshot of that volume and stores the blocks which changed
+// between them. It must be called after the new snapshot is stored.
+//
+// Comparing the snapshots reads both of them completely, so hp.mutex,
+// which the caller must hold, is released in the meantime. The new
+// snapshot only gets linked if both snapshots still exist afterwards.
+// Tracking is only an optimization for GetMetadataDelta, so failures
+// are logged and leave the snapshot without a previous snapshot.
+func (hp *hostPath) trackChangedBlocks(ctx context.Context, vol state.Volume, snapshotID string) {
+	if vol.VolAccessType != state.BlockAccess {
+		return
+	}
+	snapshot, err := hp.state.GetSnapshotByID(snapshotID)
+	if err != nil {
+		klog.Warningf("failed to track changed blocks of snapshot %s: %v", snapshotID, err)
+		return
+	}
+	var previous *state.Snapshot
+	for _, s := range hp.state.GetSnapshots() {
+		if s.VolID != vol.VolID || s.Id == snapshot.Id || !s.ReadyToUse {
+			continue
+		}
+		if previous == nil || s.CreationTime.AsTime().After(previous.CreationTime.AsTime()) {
+			previous = &s
+		}
+	}
+	if previous == nil {
+		return
+	}
+
+	blockSize := hp.snapshotBlockSize(snapshot)
+	cbtPath := hp.getChangedBlocksPath(snapshot.Id)
+	hp.mutex.Unlock()
+	bitmap, err := computeChangedBlocks(ctx, previous.Path, snapshot.Path, blockSize, hp.config.SnapshotMetadataWorkers)
+	if err == nil {
+		err = os.WriteFile(cbtPath, bitmap, 0600)
+	}
+	hp.mutex.Lock()
+	if err == nil {
+		err = hp.linkChangedBlocks(snapshot.Id, previous.Id, cbtPath, blockSize)
+	}
+	if err != nil {
+		klog.Warningf("failed to track changed blocks of snapshot %s: %v", snapshot.Id, err)
+		if err := os.RemoveAll(cbtPath); err != nil {
+			klog.Warningf("failed to remove changed blocks file %s: %v", cbtPath, err)
+		}
+		return
+	}
+	klog.V(4).Infof("tracked changed blocks of snapshot %s since snapshot %s", snapshot.Id, previous.Id)
+}
+
+// linkChangedBlocks stores that the changed blocks of a snapshot since
+// the previous snapshot are in the given file. Either snapshot may have
+// been deleted while the changed blocks were computed.
+func (hp *hostPath) linkChangedBlocks(snapshotID, previousID, cbtPath string, blockSize int64) error {
+	if _, err := hp.state.GetSnapshotByID(previousID); err != nil {
+		return fmt.Errorf("previous snapshot: %w", err)
+	}
+	snapshot, err := hp.state.GetSnapshotByID(snapshotID)
+	if err != nil {
+		return err
+	}
+	snapshot.PreviousSnapshotID = previousID
+	snapshot.ChangedBlocksPath = cbtPath
+	snapshot.ChangedBlockSize = blockSize
+	return hp.state.UpdateSnapshot(snapshot)
+}
+
+// changedBlocksBetween returns the blocks of the given size which changed
+// between the base snapshot and the target snapshot if the target is a
+// descendant of the base in a chain of snapshots tracked with that block
+// size. Otherwise it returns false.
+func (hp *hostPath) changedBlocksBetween(base, target state.Snapshot, blockSize int64) (changedBlocks, bool) {
+	var bitmap changedBlocks
+	current := target
+	for current.Id != base.Id {
+		if current.PreviousSnapshotID == "" || current.ChangedBlocksPath == "" || current.ChangedBlockSize != blockSize {
+			return nil, false
+		}
+		data, err := os.ReadFile(current.ChangedBlocksPath)
+		if err != nil {
+			klog.Warningf("failed to read changed blocks of snapshot %s: %v", current.Id, err)
+			return nil, false
+		}
+		bitmap.or(data)
+		previous, err := hp.state.GetSnapshotByID(current.PreviousSnapshotID)
+		if err != nil {
+			return nil, false
+		}
+		current = previous
+	}
+	return bitmap, true
+}
+
+// unlinkChangedBlocks removes a snapshot from its chain before it gets
+// deleted. Its changed blocks get merged into those of the following
+// snapshot, so the chain stays intact. When that is not possible, the
+// chain ends at the following snapshot.
+func (hp *hostPath) unlinkChangedBlocks(snapshotID string) error {
+	snapshot, err := hp.state.GetSnapshotByID(snapshotID)
+	if err != nil {
+		return nil
+	}
+	for _, next := range hp.state.GetSnapshots() {
+		if next.PreviousSnapshotID != snapshotID {
+			continue
+		}
+		endChain := false
+		if snapshot.PreviousSnapshotID == "" {
+			// Removing the oldest snapshot of a chain is normal.
+			klog.V(4).Infof("chain of changed blocks starts at snapshot %s after removing snapshot %s", next.Id, snapshotID)
+			endChain = true
+		} else if err := mergeChangedBlocks(snapshot, next); err != nil {
+			klog.Warningf("ending chain of changed blocks at snapshot %s: %v", next.Id, err)
+			endChain = true
+		}
+		if endChain {
+			if err := os.RemoveAll(next.ChangedBlocksPath); err != nil {
+				klog.Warningf("failed to remove changed blocks file %s: %v", next.ChangedBlocksPath, err)
+			}
+			next.PreviousSnapshotID = ""
+			next.ChangedBlocksPath = ""
+			next.ChangedBlockSize = 0
+		} else {
+			next.PreviousSnapshotID = snapshot.PreviousSnapshotID
+		}
+		if err := hp.state.UpdateSnapshot(next); err != nil {
+			return err
+		}
+	}
+	if snapshot.ChangedBlocksPath != "" {
+		if err := os.RemoveAll(snapshot.ChangedBlocksPath); err != nil {
+			klog.Warningf("failed to remove changed blocks file %s: %v", snapshot.ChangedBlocksPath, err)
+		}
+	}
+	return nil
+}
+
+// mergeChangedBlocks adds the changed blocks of a snapshot to those of
+// the next snapshot in the chain.
+func mergeChangedBlocks(snapshot, next state.Snapshot) error {
+	if snapshot.ChangedBlockSize != next.ChangedBlockSize {
+		return fmt.Errorf("block size %d of snapshot %s does not match block size %d", snapshot.ChangedBlockSize, snapshot.Id, next.ChangedBlockSize)
+	}
+	bitmap, err := os.ReadFile(next.ChangedBlocksPath)
+	if err != nil {
+		return err
+	}
+	removed, err := os.ReadFile(snapshot.ChangedBlocksPath)
+	if err != nil {
+		return err
+	}
+	merged := changedBlocks(bitmap)
+	merged.or(removed)
+	return os.WriteFile(next.ChangedBlocksPath, merged, 0600)
+}
+
+// changedBlockMetadata returns up to maxResult entries for the changed
+// blocks in the bitmap, starting at the block which contains offset.
+// Extents are limited to maxExtentLength unless it is zero.
+// It returns the offset at which the next page starts and io.EOF
+// together with the last page.
+func changedBlockMetadata(bitmap changedBlocks, offset, blockSize, maxExtentLength int64, blockMetadataType csi.BlockMetadataType, maxResult int32) ([]*csi.BlockMetadata, int64, error) {
+	result := []*csi.BlockMetadata{}
+	for blockIndex := offset / blockSize; blockIndex < bitmap.blocks(); blockIndex++ {
+		if !bitmap.isSet(blockIndex) {
+			continue
+		}
+		if extendBlock(result, blockMetadataType, blockIndex, blockSize, maxExtentLength) {
+			result[len(result)-1].SizeBytes += blockSize
+			continue
+		}
+		if int32(len(result)) >= maxResult {
+			return result, blockIndex * blockSize, nil
+		}
+		result = append(result, createBlockMetadata(blockIndex, blockSize))
+	}
+	return result, bitmap.blocks() * blockSize, io.EOF
+}
@@ -0,0 +1,258 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hostpath
+
+import (
+	"context"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/kubernetes-csi/csi-driver-host-path/pkg/state"
+)
+
+func TestChangedBlockMetadata(t *testing.T) {
+	var bitmap changedBlocks
+	for _, i := range []int64{2, 3, 4, 9, 20} {
+		bitmap.set(i)
+	}
+	assert.Equal(t, int64(24), bitmap.blocks())
+
+	testCases := []struct {
+		name              string
+		blockMetadataType csi.BlockMetadataType
+		offset            int64
+		expected          [][]*csi.BlockMetadata
+	}{
+		{
+			name: "fixed length",
+			expected: [][]*csi.BlockMetadata{
+				{createBlockMetadata(2, 512), createBlockMetadata(3, 512)},
+				{createBlockMetadata(4, 512), createBlockMetadata(9, 512)},
+				{createBlockMetadata(20, 512)},
+			},
+		},
+		{
+			name:              "variable length",
+			blockMetadataType: csi.BlockMetadataType_VARIABLE_LENGTH,
+			expected: [][]*csi.BlockMetadata{
+				{{ByteOffset: 2 * 512, SizeBytes: 3 * 512}, createBlockMetadata(9, 512)},
+				{createBlockMetadata(20, 512)},
+			},
+		},
+		{
+			name:   "starting offset",
+			offset: 4*512 + 100,
+			expected: [][]*csi.BlockMetadata{
+				{createBlockMetadata(4, 512), createBlockMetadata(9, 512)},
+				{createBlockMetadata(20, 512)},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var pages [][]*csi.BlockMetadata
+			offset := tc.offset
+			for {
+				cb, next, err := changedBlockMetadata(bitmap, offset, 512, 0, tc.blockMetadataType, 2)
+				pages = append(pages, cb)
+				if err == io.EOF {
+					break
+				}
+				require.NoError(t, err)
+				offset = next
+			}
+			assert.Equal(t, len(tc.expected), len(pages), "pages")
+			for i := range min(len(tc.expected), len(pages)) {
+				assert.Equal(t, csiBlocksString(tc.expected[i]), csiBlocksString(pages[i]), "page #%d", i)
+			}
+		})
+	}
+}
+
+func csiBlocksString(blocks []*csi.BlockMetadata) []string {
+	var result []string
+	for _, block := range blocks {
+		result = append(result, block.String())
+	}
+	return result
+}
+
+func TestChangedBlockTracking(t *testing.T) {
+	cfg := Config{
+		StateDir:   t.TempDir(),
+		Endpoint:   "unix://tmp/csi.sock",
+		DriverName: "hostpath.csi.k8s.io",
+		NodeID:     "fakeNodeID",
+	}
+	hp, err := NewHostPathDriver(cfg)
+	require.NoError(t, err)
+	vol := state.Volume{VolID: "vol", VolName: "vol", VolAccessType: state.BlockAccess}
+	require.NoError(t, hp.state.UpdateVolume(vol))
+
+	// Each snapshot changes some blocks of a 100 block volume.
+	data := make([]byte, 100*state.BlockSizeBytes)
+	creationTime := time.Now()
+	takeSnapshot := func(id string, changed ...int) state.Snapshot {
+		for _, i := range changed {
+			data[int64(i)*state.BlockSizeBytes]++
+		}
+		snapshot := state.Snapshot{
+			Id:           id,
+			Name:         id,
+			VolID:        vol.VolID,
+			Path:         hp.getSnapshotPath(id),
+			CreationTime: timestamppb.New(creationTime),
+			ReadyToUse:   true,
+		}
+		creationTime = creationTime.Add(time.Second)
+		require.NoError(t, os.WriteFile(snapshot.Path, data, 0600))
+		require.NoError(t, hp.state.UpdateSnapshot(snapshot))
+		hp.mutex.Lock()
+		hp.trackChangedBlocks(context.Background(), vol, id)
+		hp.mutex.Unlock()
+		snapshot, err := hp.state.GetSnapshotByID(id)
+		require.NoError(t, err)
+		return snapshot
+	}
+	changed := func(base, target string) []int64 {
+		baseSnapshot, err := hp.state.GetSnapshotByID(base)
+		require.NoError(t, err)
+		targetSnapshot, err := hp.state.GetSnapshotByID(target)
+		require.NoError(t, err)
+		bitmap, ok := hp.changedBlocksBetween(baseSnapshot, targetSnapshot, state.BlockSizeBytes)
+		if !ok {
+			return nil
+		}
+		result := []int64{}
+		for i := range bitmap.blocks() {
+			if bitmap.isSet(i) {
+				result = append(result, i)
+			}
+		}
+		return result
+	}
+
+	snap1 := takeSnapshot("snap-1", 1, 2)
+	assert.Empty(t, snap1.PreviousSnapshotID, "first snapshot")
+	snap2 := takeSnapshot("snap-2", 10)
+	assert.Equal(t, snap1.Id, snap2.PreviousSnapshotID)
+	takeSnapshot("snap-3", 20, 21)
+	takeSnapshot("snap-4", 99)
+
+	assert.Equal(t, []int64{10}, changed("snap-1", "snap-2"))
+	assert.Equal(t, []int64{10, 20, 21, 99}, changed("snap-1", "snap-4"))
+	assert.Equal(t, []int64{}, changed("snap-3", "snap-3"))
+	assert.Nil(t, changed("snap-4", "snap-1"), "no chain backwards")
+
+	// Removing a snapshot in the middle keeps the chain intact.
+	require.NoError(t, hp.removeSnapshot("snap-3"))
+	assert.NoFileExists(t, hp.getChangedBlocksPath("snap-3"))
+	assert.Equal(t, []int64{20, 21, 99}, changed("snap-2", "snap-4"))
+
+	// Removing the first snapshot ends the chain.
+	require.NoError(t, hp.removeSnapshot("snap-1"))
+	snap2, err = hp.state.GetSnapshotByID("snap-2")
+	require.NoError(t, err)
+	assert.Empty(t, snap2.PreviousSnapshotID, "new first snapshot")
+	assert.NoFileExists(t, hp.getChangedBlocksPath("snap-2"))
+	assert.Equal(t, []int64{20, 21, 99}, changed("snap-2", "snap-4"))
+}
+
+// newTwoSnapshotDriver returns a driver with a block volume and two
+// snapshots of it that are not linked yet.
+func newTwoSnapshotDriver(t *testing.T) (*hostPath, state.Volume) {
+	cfg := Config{
+		StateDir:   t.TempDir(),
+		Endpoint:   "unix://tmp/csi.sock",
+		DriverName: "hostpath.csi.k8s.io",
+		NodeID:     "fakeNodeID",
+	}
+	hp, err := NewHostPathDriver(cfg)
+	require.NoError(t, err)
+	vol := state.Volume{VolID: "vol", VolName: "vol", VolAccessType: state.BlockAccess}
+	require.NoError(t, hp.state.UpdateVolume(vol))
+	creationTime := time.Now()
+	for _, id := range []string{"snap-1", "snap-2"} {
+		snapshot := state.Snapshot{
+			Id:           id,
+			Name:         id,
+			VolID:        vol.VolID,
+			Path:         hp.getSnapshotPath(id),
+			CreationTime: timestamppb.New(creationTime),
+			ReadyToUse:   true,
+		}
+		creationTime = creationTime.Add(time.Second)
+		require.NoError(t, os.WriteFile(snapshot.Path, make([]byte, 4*state.BlockSizeBytes), 0600))
+		require.NoError(t, hp.state.UpdateSnapshot(snapshot))
+	}
+	return hp, vol
+}
+
+func TestChangedBlockTrackingConcurrentDelete(t *testing.T) {
+	testCases := []struct {
+		name    string
+		deleted string
+	}{
+		{name: "previous snapshot deleted", deleted: "snap-1"},
+		{name: "new snapshot deleted", deleted: "snap-2"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			hp, vol := newTwoSnapshotDriver(t)
+			defer func(orig func(context.Context, string, string, int64, int) (changedBlocks, error)) {
+				computeChangedBlocks = orig
+			}(computeChangedBlocks)
+			computeChangedBlocks = func(ctx context.Context, basePath, targetPath string, blockSize int64, workers int) (changedBlocks, error) {
+				// Other calls can proceed while the snapshots
+				// get compared.
+				hp.mutex.Lock()
+				defer hp.mutex.Unlock()
+				assert.NoError(t, hp.state.DeleteSnapshot(tc.deleted))
+				return changedBlocks{1}, nil
+			}
+
+			hp.mutex.Lock()
+			hp.trackChangedBlocks(context.Background(), vol, "snap-2")
+			hp.mutex.Unlock()
+			assert.NoFileExists(t, hp.getChangedBlocksPath("snap-2"))
+			if snapshot, err := hp.state.GetSnapshotByID("snap-2"); err == nil {
+				assert.Empty(t, snapshot.PreviousSnapshotID)
+				assert.Empty(t, snapshot.ChangedBlocksPath)
+			}
+		})
+	}
+}
+
+func TestChangedBlockTrackingUpdateFailure(t *testing.T) {
+	hp, vol := newTwoSnapshotDriver(t)
+	hp.state = failingSnapshotState{State: hp.state, volID: vol.VolID}
+	hp.mutex.Lock()
+	hp.trackChangedBlocks(context.Background(), vol, "snap-2")
+	hp.mutex.Unlock()
+	assert.NoFileExists(t, hp.getChangedBlocksPath("snap-2"))
+	snapshot, err := hp.state.GetSnapshotByID("snap-2")
+	require.NoError(t, err)
+	assert.Empty(t, snapshot.PreviousSnapshotID)
+}
@@ -109,6 +109,12 @@ func (hp *hostPath) CreateVolume(ctx context.Context, req *csi.CreateVolumeReque
 		capacity = limit
 	}
 
+	cloneMode, err := cloneModeFromParameters(req.GetParameters())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid storage class parameters: %s", err.Error())
+	}
+	groupSnapshotID := req.GetParameters()[groupSnapshotIDParameterName]
+
 	// Lock before acting on global state. A production-quality
 	// driver might use more fine-grained locking.
 	hp.mutex.Lock()
@@ -128,6 +134,9 @@ func (hp *hostPath) CreateVolume(ctx context.Context, req *csi.CreateVolumeReque
 		if exVol.VolSize < capacity || (limit > 0 && exVol.VolSize > limit) {
 			return nil, status.Errorf(codes.AlreadyExists, "Volume with the same name: %s but with different size already exist", req.GetName())
 		}
+		if exVol.GroupSnapshotID != groupSnapshotID {
+			return nil, status.Errorf(codes.AlreadyExists, "Volume with the same name: %s but with different group snapshot already exist", req.GetName())
+		}
 		if req.GetVolumeContentSource() != nil {
 			volumeSource := req.VolumeContentSource
 			switch volumeSource.Type.(type) {
@@ -155,10 +164,18 @@ func (hp *hostPath) CreateVolume(ctx context.Context, req *csi.CreateVolumeReque
 		}, nil
 	}
 
+	if groupSnapshotID != "" {
+		if err := hp.checkGroupRestore(groupSnapshotID, req.GetVolumeContentSource()); err != nil {
+			return nil, err
+		}
+	}
+
 	volumeID := uuid.NewUUID().String()
 	kind := req.GetParameters()[storageKind]
-	// This code does not check whether hp.createVolume rounds capacity up;
-	// a more robust driver would ensure any rounding does not exceed limit.
+	capacity, err = hp.getVolumeSize(kind, capacity, limit, requestedAccessType)
+	if err != nil {
+		return nil, err
+	}
 	vol, err := hp.createVolume(volumeID, req.GetName(), capacity, requestedAccessType, false /* ephemeral */, kind)
 	if err != nil {
 		return nil, err
@@ -171,17 +188,29 @@ func (hp *hostPath) CreateVolume(ctx context.Context, req *csi.CreateVolumeReque
 		switch volumeSource.Type.(type) {
 		case *csi.VolumeContentSource_Snapshot:
 			if snapshot := volumeSource.GetSnapshot(); snapshot != nil {
-				err = hp.loadFromSnapshot(capacity, snapshot.GetSnapshotId(), path, requestedAccessType)
+				switch {
+				case requestedAccessType == state.MountAccess && isReadOnlyRequest(caps):
+					// All read-only restores of the same snapshot share one copy.
+					err = hp.loadFromSnapshotShared(capacity, snapshot.GetSnapshotId(), vol)
+				case requestedAccessType == state.MountAccess && cloneMode == cloneModeOverlay:
+					err = hp.loadFromSnapshotOverlay(capacity, snapshot.GetSnapshotId(), vol)
+				default:
+					err = hp.loadFromSnapshot(capacity, snapshot.GetSnapshotId(), path, requestedAccessType)
+				}
 				vol.ParentSnapID = snapshot.GetSnapshotId()
+				vol.GroupSnapshotID = groupSnapshotID
 			}
 		case *csi.VolumeContentSource_Volume:
 			if srcVolume := volumeSource.GetVolume(); srcVolume != nil {
-				err = hp.loadFromVolume(capacity, srcVolume.GetVolumeId(), path, requestedAccessType)
+				err = hp.loadFromVolume(ctx, capacity, srcVolume.GetVolumeId(), path, requestedAccessType)
 				vol.ParentVolID = srcVolume.GetVolumeId()
 			}
 		default:
 			err = status.Errorf(codes.InvalidArgument, "%v not a proper volume source", volumeSource)
 		}
+		if err == nil {
+			err = hp.state.UpdateVolume(*vol)
+		}
 		if err != nil {
 			klog.V(4).Infof("VolumeSource error: %v", err)
 			if delErr := hp.deleteVolume(volumeID); delErr != nil {
@@ -195,7 +224,7 @@ func (hp *hostPath) CreateVolume(ctx context.Context, req *csi.CreateVolumeReque
 	return &csi.CreateVolumeResponse{
 		Volume: &csi.Volume{
 			VolumeId:           volumeID,
-			CapacityBytes:      capacity,
+			CapacityBytes:      vol.VolSize,
 			VolumeContext:      req.GetParameters(),
 			ContentSource:      req.GetVolumeContentSource(),
 			AccessibleTopology: topologies,
@@ -393,27 +422,35 @@ func (hp *hostPath) GetCapacity(ctx context.Context, req *csi.GetCapacityRequest
 	// distinguish based on the "kind" parameter, if at all.
 	// Without configured capacity, we just have the maximum size.
 	available := hp.config.MaxVolumeSize
+	kind := req.GetParameters()[storageKind]
 	if hp.config.Capacity.Enabled() {
 		// Empty "kind" will return "zero capacity". There is no fallback
 		// to some arbitrary kind here because in practice it always should
 		// be set.
-		kind := req.GetParameters()[storageKind]
-		quantity := hp.config.Capacity[kind]
-		allocated := hp.sumVolumeSizes(kind)
-		available = quantity.Value() - allocated
+		var err error
+		available, err = hp.availableCapacity(kind)
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
 	}
 	maxVolumeSize := hp.config.MaxVolumeSize
 	if maxVolumeSize > available {
 		maxVolumeSize = available
 	}
+	limits := hp.config.VolumeSizeLimits[kind]
+	if limits.Max > 0 && maxVolumeSize > limits.Max {
+		maxVolumeSize = limits.Max
+	}
+	// Larger volumes would get rounded up beyond the maximum.
+	maxVolumeSize = limits.roundDown(maxVolumeSize)
 
 	return &csi.GetCapacityResponse{
 		AvailableCapacity: available,
 		MaximumVolumeSize: &wrapperspb.Int64Value{Value: maxVolumeSize},
 
-		// We don't have a minimum volume size, so we might as well report that.
-		// Better explicit than implicit...
-		MinimumVolumeSize: &wrapperspb.Int64Value{Value: 0},
+		// Without a configured minimum volume size, we might as well
+		// report zero. Better explicit than implicit...
+		MinimumVolumeSize: &wrapperspb.Int64Value{Value: limits.roundUp(0)},
 	}, nil
 }
 
@@ -456,8 +493,8 @@ func (hp *hostPath) ListVolumes(ctx context.Context, req *csi.ListVolumesRequest
 
 	for index := startIdx - 1; index < volumesLength && index < maxLength; index++ {
 		hpVolume = volumes[index]
-		healthy, msg := hp.doHealthCheckInControllerSide(hpVolume.VolID)
-		klog.V(3).Infof("Healthy state: %s Volume: %t", hpVolume.VolName, healthy)
+		condition := hp.getVolumeCondition(hpVolume.VolID, controllerSide)
+		klog.V(3).Infof("Healthy state: %s Volume: %t", hpVolume.VolName, !condition.Abnormal)
 		volumeRes.Entries = append(volumeRes.Entries, &csi.ListVolumesResponse_Entry{
 			Volume: &csi.Volume{
 				VolumeId:      hpVolume.VolID,
@@ -465,10 +502,7 @@ func (hp *hostPath) ListVolumes(ctx context.Context, req *csi.ListVolumesRequest
 			},
 			Status: &csi.ListVolumesResponse_VolumeStatus{
 				PublishedNodeIds: []string{hpVolume.NodeID},
-				VolumeCondition: &csi.VolumeCondition{
-					Abnormal: !healthy,
-					Message:  msg,
-				},
+				VolumeCondition:  condition,
 			},
 		})
 	}
@@ -499,19 +533,17 @@ func (hp *hostPath) ControllerGetVolume(ctx context.Context, req *csi.Controller
 		}, nil
 	}
 
-	healthy, msg := hp.doHealthCheckInControllerSide(req.GetVolumeId())
-	klog.V(3).Infof("Healthy state: %s Volume: %t", volume.VolName, healthy)
+	condition := hp.getVolumeCondition(req.GetVolumeId(), controllerSide)
+	klog.V(3).Infof("Healthy state: %s Volume: %t", volume.VolName, !condition.Abnormal)
 	return &csi.ControllerGetVolumeResponse{
 		Volume: &csi.Volume{
 			VolumeId:      volume.VolID,
 			CapacityBytes: volume.VolSize,
+			VolumeContext: hp.groupRestoreContext(volume.GroupSnapshotID),
 		},
 		Status: &csi.ControllerGetVolumeResponse_VolumeStatus{
 			PublishedNodeIds: []string{volume.NodeID},
-			VolumeCondition: &csi.VolumeCondition{
-				Abnormal: !healthy,
-				Message:  msg,
-			},
+			VolumeCondition:  condition,
 		},
 	}, nil
 }
@@ -602,10 +634,19 @@ func (hp *hostPath) CreateSnapshot(ctx context.Context, req *csi.CreateSnapshotR
 	if err != nil {
 		return nil, status.Errorf(codes.InvalidArgument, "invalid volume snapshot class parameters: %s", err.Error())
 	}
+	blockSize, blockMetadataType, err := snapshotMetadataFromParameters(req.Parameters)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid volume snapshot class parameters: %s", err.Error())
+	}
 
-	if err := hp.createSnapshotFromVolume(hostPathVolume, file, opts...); err != nil {
+	if err := hp.createSnapshotFromVolume(ctx, hostPathVolume, file, opts...); err != nil {
 		return nil, err
 	}
+	checksum, err := fileChecksum(file)
+	if err != nil {
+		os.RemoveAll(file)
+		return nil, status.Errorf(codes.Internal, "failed to compute checksum of snapshot %s: %v", file, err)
+	}
 
 	klog.V(4).Infof("create volume snapshot %s", file)
 	snapshot := state.Snapshot{}
@@ -616,10 +657,18 @@ func (hp *hostPath) CreateSnapshot(ctx context.Context, req *csi.CreateSnapshotR
 	snapshot.CreationTime = creationTime
 	snapshot.SizeBytes = hostPathVolume.VolSize
 	snapshot.ReadyToUse = true
+	snapshot.Checksum = checksum
+	snapshot.BlockSize = blockSize
+	snapshot.BlockMetadataType = blockMetadataType
+	if err := hp.chargeSnapshot(&snapshot, hostPathVolume.Kind); err != nil {
+		os.RemoveAll(file)
+		return nil, err
+	}
 
 	if err := hp.state.UpdateSnapshot(snapshot); err != nil {
 		return nil, err
 	}
+	hp.trackChangedBlocks(ctx, hostPathVolume, snapshot.Id)
 	return &csi.CreateSnapshotResponse{
 		Snapshot: &csi.Snapshot{
 			SnapshotId:     snapshot.Id,
@@ -659,9 +708,7 @@ func (hp *hostPath) DeleteSnapshot(ctx context.Context, req *csi.DeleteSnapshotR
 	}
 
 	klog.V(4).Infof("deleting snapshot %s", snapshotID)
-	path := hp.getSnapshotPath(snapshotID)
-	os.RemoveAll(path)
-	if err := hp.state.DeleteSnapshot(snapshotID); err != nil {
+	if err := hp.removeSnapshot(snapshotID); err != nil {
 		return nil, err
 	}
 	return &csi.DeleteSnapshotResponse{}, nil
@@ -842,6 +889,10 @@ func (hp *hostPath) ControllerExpandVolume(ctx context.Context, req *csi.Control
 	}
 
 	if exVol.VolSize < capacity {
+		capacity, err = hp.getVolumeSize(exVol.Kind, capacity, capRange.GetLimitBytes(), exVol.VolAccessType)
+		if err != nil {
+			return nil, err
+		}
 		exVol.VolSize = capacity
 		if err := hp.state.UpdateVolume(exVol); err != nil {
 			return nil, err
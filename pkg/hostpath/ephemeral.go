@@ -0,0 +1,221 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hostpath
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/klog/v2"
+
+	"github.com/kubernetes-csi/csi-driver-host-path/pkg/state"
+)
+
+// Volume attributes of CSI ephemeral inline volumes, in addition to
+// storageKind.
+const (
+	// ephemeralSizeAttribute is the size of the volume as a resource
+	// quantity.
+	ephemeralSizeAttribute = "size"
+	// ephemeralSnapshotAttribute is the ID of a snapshot of a
+	// filesystem volume which the volume gets populated from.
+	ephemeralSnapshotAttribute = "snapshotID"
+	// ephemeralTemplateAttribute is a host directory which gets copied
+	// into the volume. It must be inside one of the directories
+	// configured with EphemeralTemplateDirs.
+	ephemeralTemplateAttribute = "templateDir"
+
+	defaultEphemeralVolumeSize = 100 * mib
+)
+
+// ephemeralVolumeParameters are the validated attributes of an ephemeral
+// inline volume.
+type ephemeralVolumeParameters struct {
+	size        int64
+	kind        string
+	snapshotID  string
+	templateDir string
+}
+
+// parseEphemeralAttributes validates the volume attributes of an ephemeral
+// inline volume. Attributes which are not about the volume content, like
+// the ones added by kubelet, are ignored.
+func (hp *hostPath) parseEphemeralAttributes(attributes map[string]string) (*ephemeralVolumeParameters, error) {
+	params := &ephemeralVolumeParameters{
+		size:        defaultEphemeralVolumeSize,
+		kind:        attributes[storageKind],
+		snapshotID:  attributes[ephemeralSnapshotAttribute],
+		templateDir: attributes[ephemeralTemplateAttribute],
+	}
+	if value, ok := attributes[ephemeralSizeAttribute]; ok {
+		size, err := resource.ParseQuantity(value)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid %s %q for ephemeral volume: %v", ephemeralSizeAttribute, value, err)
+		}
+		if size.Sign() <= 0 {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid %s %q for ephemeral volume: must be positive", ephemeralSizeAttribute, value)
+		}
+		params.size = size.Value()
+	}
+
+	switch {
+	case params.snapshotID != "" && params.templateDir != "":
+		return nil, status.Errorf(codes.InvalidArgument, "ephemeral volume cannot have both %s and %s", ephemeralSnapshotAttribute, ephemeralTemplateAttribute)
+	case params.snapshotID != "":
+		if err := hp.checkEphemeralSnapshot(params.snapshotID); err != nil {
+			return nil, err
+		}
+	case params.templateDir != "":
+		templateDir, err := hp.checkEphemeralTemplate(params.templateDir)
+		if err != nil {
+			return nil, err
+		}
+		params.templateDir = templateDir
+	}
+	return params, nil
+}
+
+// checkEphemeralSnapshot ensures that the snapshot exists and is not the
+// raw image of a block volume, which cannot be extracted into a directory.
+// The source volume may be gone, so the snapshot file itself tells what
+// it is.
+func (hp *hostPath) checkEphemeralSnapshot(snapshotID string) error {
+	snapshot, err := hp.state.GetSnapshotByID(snapshotID)
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "invalid %s %q for ephemeral volume: %v", ephemeralSnapshotAttribute, snapshotID, err)
+	}
+	isArchive, err := isSnapshotArchive(snapshot.Path)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to check snapshot %s: %v", snapshotID, err)
+	}
+	if !isArchive {
+		return status.Errorf(codes.InvalidArgument, "invalid %s %q for ephemeral volume: snapshot of block volume %s", ephemeralSnapshotAttribute, snapshotID, snapshot.VolID)
+	}
+	return nil
+}
+
+// gzipMagic starts every gzip file.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// isSnapshotArchive returns true if the snapshot file is a compressed tar
+// archive of a filesystem volume and not the raw image of a block volume.
+func isSnapshotArchive(path string) (bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+	magic := make([]byte, len(gzipMagic))
+	if _, err := io.ReadFull(file, magic); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return false, nil
+		}
+		return false, err
+	}
+	return bytes.Equal(magic, gzipMagic), nil
+}
+
+// checkEphemeralTemplate resolves the template directory and ensures that
+// it is inside one of the allowed directories, also after following
+// symlinks.
+func (hp *hostPath) checkEphemeralTemplate(templateDir string) (string, error) {
+	if len(hp.config.EphemeralTemplateDirs) == 0 {
+		return "", status.Errorf(codes.InvalidArgument, "%s is not supported for ephemeral volumes because no template directories are configured", ephemeralTemplateAttribute)
+	}
+	if !filepath.IsAbs(templateDir) {
+		return "", status.Errorf(codes.InvalidArgument, "invalid %s %q for ephemeral volume: must be an absolute path", ephemeralTemplateAttribute, templateDir)
+	}
+	resolved, err := filepath.EvalSymlinks(templateDir)
+	if err != nil {
+		return "", status.Errorf(codes.InvalidArgument, "invalid %s %q for ephemeral volume: %v", ephemeralTemplateAttribute, templateDir, err)
+	}
+	info, err := os.Stat(resolved)
+	if err != nil {
+		return "", status.Errorf(codes.InvalidArgument, "invalid %s %q for ephemeral volume: %v", ephemeralTemplateAttribute, templateDir, err)
+	}
+	if !info.IsDir() {
+		return "", status.Errorf(codes.InvalidArgument, "invalid %s %q for ephemeral volume: not a directory", ephemeralTemplateAttribute, templateDir)
+	}
+	for _, allowed := range hp.config.EphemeralTemplateDirs {
+		allowedDir, err := filepath.EvalSymlinks(allowed)
+		if err != nil {
+			klog.Warningf("ignoring ephemeral template directory %s: %v", allowed, err)
+			continue
+		}
+		rel, err := filepath.Rel(allowedDir, resolved)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, "../") {
+			return resolved, nil
+		}
+	}
+	return "", status.Errorf(codes.InvalidArgument, "invalid %s %q for ephemeral volume: not inside the allowed directories %v", ephemeralTemplateAttribute, templateDir, []string(hp.config.EphemeralTemplateDirs))
+}
+
+// createEphemeralVolume creates and populates an ephemeral inline volume.
+// Its size is subject to the same limits and capacity checks as the size of
+// persistent volumes.
+func (hp *hostPath) createEphemeralVolume(volID, name string, attributes map[string]string) (*state.Volume, error) {
+	params, err := hp.parseEphemeralAttributes(attributes)
+	if err != nil {
+		return nil, err
+	}
+	size, err := hp.getVolumeSize(params.kind, params.size, 0, state.MountAccess)
+	if err != nil {
+		return nil, err
+	}
+	if params.templateDir != "" {
+		usage, err := walkUsage(params.templateDir)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid %s %q for ephemeral volume: %v", ephemeralTemplateAttribute, params.templateDir, err)
+		}
+		if usage.Bytes > size {
+			return nil, status.Errorf(codes.InvalidArgument, "%s %q uses %d bytes, more than the ephemeral volume size %d", ephemeralTemplateAttribute, params.templateDir, usage.Bytes, size)
+		}
+	}
+
+	vol, err := hp.createVolume(volID, name, size, state.MountAccess, true /* ephemeral */, params.kind)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case params.snapshotID != "":
+		err = hp.loadFromSnapshot(size, params.snapshotID, vol.VolPath, state.MountAccess)
+		vol.ParentSnapID = params.snapshotID
+	case params.templateDir != "":
+		var method string
+		method, err = copyDirectory(params.templateDir, vol.VolPath)
+		if err == nil {
+			recordCopyMethod(copyOperationPopulateTemplate, method, params.templateDir, vol.VolPath)
+		}
+	}
+	if err == nil {
+		err = hp.state.UpdateVolume(*vol)
+	}
+	if err != nil {
+		if delErr := hp.deleteVolume(volID); delErr != nil {
+			klog.V(2).Infof("deleting ephemeral volume %v failed: %v", volID, delErr)
+		}
+		return nil, status.Errorf(status.Code(err), "failed to populate ephemeral volume %s: %v", volID, err)
+	}
+	return vol, nil
+}
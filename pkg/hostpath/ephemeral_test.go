@@ -0,0 +1,215 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hostpath
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"github.com/kubernetes-csi/csi-driver-host-path/pkg/state"
+)
+
+func TestCreateEphemeralVolume(t *testing.T) {
+	tmpDir := t.TempDir()
+	templates := filepath.Join(tmpDir, "templates")
+	require.NoError(t, os.MkdirAll(filepath.Join(templates, "web"), 0750))
+	require.NoError(t, os.WriteFile(filepath.Join(templates, "web", "index.html"), []byte("template"), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(templates, "file"), []byte("not a directory"), 0600))
+	require.NoError(t, os.MkdirAll(filepath.Join(tmpDir, "secret"), 0750))
+	require.NoError(t, os.Symlink(filepath.Join(tmpDir, "secret"), filepath.Join(templates, "escape")))
+
+	hp, err := NewHostPathDriver(Config{
+		StateDir:      filepath.Join(tmpDir, "state"),
+		Endpoint:      "unix://tmp/csi.sock",
+		DriverName:    "hostpath.csi.k8s.io",
+		NodeID:        "fakeNodeID",
+		MaxVolumeSize: 1 << 62,
+		Capacity: Capacity{
+			"small": resource.MustParse("1Mi"),
+			"large": resource.MustParse("2Gi"),
+		},
+		EphemeralTemplateDirs: StringArray{templates},
+	})
+	require.NoError(t, err)
+
+	content := filepath.Join(tmpDir, "content")
+	require.NoError(t, os.Mkdir(content, 0750))
+	require.NoError(t, os.WriteFile(filepath.Join(content, "data"), []byte("snapshot"), 0600))
+	snapshotPath := hp.getSnapshotPath("snap")
+	out, err := exec.Command("tar", "czf", snapshotPath, "-C", content, ".").CombinedOutput()
+	require.NoError(t, err, "create snapshot: %s", out)
+	require.NoError(t, hp.state.UpdateSnapshot(state.Snapshot{
+		Id:         "snap",
+		Name:       "snap-name",
+		VolID:      "source",
+		Path:       snapshotPath,
+		SizeBytes:  1024,
+		ReadyToUse: true,
+	}))
+	// The raw image of a block volume which was deleted since.
+	blockSnapshotPath := hp.getSnapshotPath("block-snap")
+	require.NoError(t, os.WriteFile(blockSnapshotPath, make([]byte, 4096), 0600))
+	require.NoError(t, hp.state.UpdateSnapshot(state.Snapshot{
+		Id:         "block-snap",
+		Name:       "block-snap-name",
+		VolID:      "deleted-block-volume",
+		Path:       blockSnapshotPath,
+		SizeBytes:  4096,
+		ReadyToUse: true,
+	}))
+
+	testCases := []struct {
+		name        string
+		attributes  map[string]string
+		wantCode    codes.Code
+		wantSize    int64
+		wantFile    string
+		wantContent string
+	}{
+		{
+			name:     "defaults",
+			wantSize: 100 * mib,
+			wantCode: codes.OK,
+		},
+		{
+			name:       "size",
+			attributes: map[string]string{ephemeralSizeAttribute: "1Gi"},
+			wantSize:   gib,
+			wantCode:   codes.OK,
+		},
+		{
+			name:       "invalid size",
+			attributes: map[string]string{ephemeralSizeAttribute: "lots"},
+			wantCode:   codes.InvalidArgument,
+		},
+		{
+			name:       "negative size",
+			attributes: map[string]string{ephemeralSizeAttribute: "-1Mi"},
+			wantCode:   codes.InvalidArgument,
+		},
+		{
+			name:       "kind",
+			attributes: map[string]string{ephemeralSizeAttribute: "512Ki", storageKind: "small"},
+			wantSize:   512 * kib,
+			wantCode:   codes.OK,
+		},
+		{
+			name:       "kind exhausted",
+			attributes: map[string]string{ephemeralSizeAttribute: "2Mi", storageKind: "small"},
+			wantCode:   codes.ResourceExhausted,
+		},
+		{
+			name:        "snapshot",
+			attributes:  map[string]string{ephemeralSnapshotAttribute: "snap"},
+			wantSize:    100 * mib,
+			wantCode:    codes.OK,
+			wantFile:    "data",
+			wantContent: "snapshot",
+		},
+		{
+			name:       "unknown snapshot",
+			attributes: map[string]string{ephemeralSnapshotAttribute: "no-such-snapshot"},
+			wantCode:   codes.InvalidArgument,
+		},
+		{
+			name:       "block snapshot",
+			attributes: map[string]string{ephemeralSnapshotAttribute: "block-snap"},
+			wantCode:   codes.InvalidArgument,
+		},
+		{
+			name:        "template",
+			attributes:  map[string]string{ephemeralTemplateAttribute: filepath.Join(templates, "web")},
+			wantSize:    100 * mib,
+			wantCode:    codes.OK,
+			wantFile:    "index.html",
+			wantContent: "template",
+		},
+		{
+			name:       "template not allowed",
+			attributes: map[string]string{ephemeralTemplateAttribute: content},
+			wantCode:   codes.InvalidArgument,
+		},
+		{
+			name:       "template escaping with dot dot",
+			attributes: map[string]string{ephemeralTemplateAttribute: templates + "/../content"},
+			wantCode:   codes.InvalidArgument,
+		},
+		{
+			name:       "template escaping with symlink",
+			attributes: map[string]string{ephemeralTemplateAttribute: filepath.Join(templates, "escape")},
+			wantCode:   codes.InvalidArgument,
+		},
+		{
+			name:       "relative template",
+			attributes: map[string]string{ephemeralTemplateAttribute: "templates/web"},
+			wantCode:   codes.InvalidArgument,
+		},
+		{
+			name:       "template is a file",
+			attributes: map[string]string{ephemeralTemplateAttribute: filepath.Join(templates, "file")},
+			wantCode:   codes.InvalidArgument,
+		},
+		{
+			name:       "template larger than volume",
+			attributes: map[string]string{ephemeralTemplateAttribute: filepath.Join(templates, "web"), ephemeralSizeAttribute: "1"},
+			wantCode:   codes.InvalidArgument,
+		},
+		{
+			name: "snapshot and template",
+			attributes: map[string]string{
+				ephemeralSnapshotAttribute: "snap",
+				ephemeralTemplateAttribute: filepath.Join(templates, "web"),
+			},
+			wantCode: codes.InvalidArgument,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			volID := "ephemeral-" + filepath.Base(t.Name())
+			vol, err := hp.createEphemeralVolume(volID, volID, tc.attributes)
+			require.Equal(t, tc.wantCode, status.Code(err), "unexpected error: %v", err)
+			if tc.wantCode != codes.OK {
+				_, err := hp.state.GetVolumeByID(volID)
+				assert.Error(t, err, "failed volume must not be in state")
+				return
+			}
+			defer func() {
+				assert.NoError(t, hp.deleteVolume(volID))
+			}()
+
+			assert.True(t, vol.Ephemeral)
+			assert.Equal(t, tc.wantSize, vol.VolSize)
+			if kind := tc.attributes[storageKind]; kind != "" {
+				assert.Equal(t, kind, vol.Kind)
+			}
+			if tc.wantFile != "" {
+				data, err := os.ReadFile(filepath.Join(vol.VolPath, tc.wantFile))
+				require.NoError(t, err)
+				assert.Equal(t, tc.wantContent, string(data))
+			}
+		})
+	}
+}
@@ -20,6 +20,7 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"strconv"
 	"strings"
 
 	"k8s.io/apimachinery/pkg/api/resource"
@@ -70,6 +71,204 @@ func (c *Capacity) Enabled() bool {
 	return len(*c) > 0
 }
 
+// CapacityDirs maps storage kinds to the directories where their
+// volumes get created, instead of the state directory. The free space
+// of the filesystem with the directory then limits the capacity of the
+// kind in addition to the configured capacity.
+//
+// It is configured with a command line flag -capacity-dir
+// <type>=<directory>, which may be used once per kind.
+type CapacityDirs map[string]string
+
+// Set is an implementation of flag.Value.Set.
+func (c *CapacityDirs) Set(arg string) error {
+	parts := strings.SplitN(arg, "=", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return errors.New("must be of format <type>=<directory>")
+	}
+
+	// We overwrite any previous value.
+	if *c == nil {
+		*c = CapacityDirs{}
+	}
+	(*c)[parts[0]] = parts[1]
+	return nil
+}
+
+func (c *CapacityDirs) String() string {
+	return fmt.Sprintf("%v", map[string]string(*c))
+}
+
+var _ flag.Value = &CapacityDirs{}
+
+// CapacityPolicy defines how the volumes of a kind are charged against
+// its capacity.
+type CapacityPolicy struct {
+	// Thin volumes do not reserve their size. Thick provisioning
+	// is the default.
+	Thin bool
+	// Overcommit is the ratio between the total size of thin volumes
+	// and the capacity of the kind.
+	Overcommit float64
+	// HighWatermark is the percentage of the capacity which the data
+	// actually stored in thin volumes may use before no more
+	// volumes get created. Zero disables the check.
+	HighWatermark int
+}
+
+// provisioningLimit returns how much of the capacity can be handed out
+// to volumes.
+func (p CapacityPolicy) provisioningLimit(capacity int64) int64 {
+	if !p.Thin {
+		return capacity
+	}
+	return int64(float64(capacity) * p.Overcommit)
+}
+
+// CapacityPolicies configures thin provisioning per kind. It is
+// configured with a command line flag -capacity-provisioning
+// <type>=thick or <type>=thin[,overcommit=<ratio>][,watermark=<percent>].
+type CapacityPolicies map[string]CapacityPolicy
+
+// Set is an implementation of flag.Value.Set.
+func (c *CapacityPolicies) Set(arg string) error {
+	parts := strings.SplitN(arg, "=", 2)
+	if len(parts) != 2 {
+		return errors.New("must be of format <type>=thick or <type>=thin[,overcommit=<ratio>][,watermark=<percent>]")
+	}
+	options := strings.Split(parts[1], ",")
+	var policy CapacityPolicy
+	switch options[0] {
+	case "thick":
+		if len(options) > 1 {
+			return errors.New("thick provisioning has no options")
+		}
+	case "thin":
+		policy = CapacityPolicy{Thin: true, Overcommit: 1}
+		for _, option := range options[1:] {
+			key, value, _ := strings.Cut(option, "=")
+			switch key {
+			case "overcommit":
+				ratio, err := strconv.ParseFloat(value, 64)
+				if err != nil || ratio < 1 {
+					return fmt.Errorf("overcommit ratio must be a number of at least 1, got %q", value)
+				}
+				policy.Overcommit = ratio
+			case "watermark":
+				percent, err := strconv.Atoi(value)
+				if err != nil || percent < 1 || percent > 100 {
+					return fmt.Errorf("watermark must be a percentage between 1 and 100, got %q", value)
+				}
+				policy.HighWatermark = percent
+			default:
+				return fmt.Errorf("unknown thin provisioning option %q", option)
+			}
+		}
+	default:
+		return fmt.Errorf("provisioning must be thick or thin, got %q", options[0])
+	}
+
+	// We overwrite any previous value.
+	if *c == nil {
+		*c = CapacityPolicies{}
+	}
+	(*c)[parts[0]] = policy
+	return nil
+}
+
+func (c *CapacityPolicies) String() string {
+	return fmt.Sprintf("%v", map[string]CapacityPolicy(*c))
+}
+
+var _ flag.Value = &CapacityPolicies{}
+
+// SizeLimits restricts the size of the volumes of a kind.
+type SizeLimits struct {
+	// Min is the minimum size. Smaller requests get rounded up.
+	Min int64
+	// Max is the maximum size. Zero means that only the global
+	// maximum volume size applies.
+	Max int64
+	// Granularity is the unit in which volumes get allocated. Sizes
+	// get rounded up to a multiple of it. Zero means bytes.
+	Granularity int64
+}
+
+// roundUp returns the smallest size which is at least the requested
+// size and satisfies the minimum and the granularity.
+func (l SizeLimits) roundUp(size int64) int64 {
+	size = max(size, l.Min)
+	if l.Granularity > 0 {
+		size = (size + l.Granularity - 1) / l.Granularity * l.Granularity
+	}
+	return size
+}
+
+// roundDown returns the largest size which is at most the given size
+// and a multiple of the granularity.
+func (l SizeLimits) roundDown(size int64) int64 {
+	if l.Granularity > 0 {
+		size = size / l.Granularity * l.Granularity
+	}
+	return size
+}
+
+// accepts returns true if a volume can have exactly the given size.
+func (l SizeLimits) accepts(size int64) bool {
+	return l.roundUp(size) == size && (l.Max == 0 || size <= l.Max)
+}
+
+// VolumeSizeLimits configures SizeLimits per kind with a command line
+// flag -volume-size-limits
+// <type>=[min=<size>][,max=<size>][,granularity=<size>], where <size>
+// is a quantity.
+type VolumeSizeLimits map[string]SizeLimits
+
+// Set is an implementation of flag.Value.Set.
+func (v *VolumeSizeLimits) Set(arg string) error {
+	parts := strings.SplitN(arg, "=", 2)
+	if len(parts) != 2 {
+		return errors.New("must be of format <type>=[min=<size>][,max=<size>][,granularity=<size>]")
+	}
+	var limits SizeLimits
+	for _, option := range strings.Split(parts[1], ",") {
+		key, value, _ := strings.Cut(option, "=")
+		quantity, err := resource.ParseQuantity(value)
+		if err != nil {
+			return fmt.Errorf("%s: %v", key, err)
+		}
+		if quantity.Sign() < 0 {
+			return fmt.Errorf("%s must not be negative", key)
+		}
+		switch key {
+		case "min":
+			limits.Min = quantity.Value()
+		case "max":
+			limits.Max = quantity.Value()
+		case "granularity":
+			limits.Granularity = quantity.Value()
+		default:
+			return fmt.Errorf("unknown size limit %q", key)
+		}
+	}
+	if limits.Max > 0 && limits.roundDown(limits.Max) < limits.roundUp(1) {
+		return errors.New("no size between the minimum and the maximum is a multiple of the granularity")
+	}
+
+	// We overwrite any previous value.
+	if *v == nil {
+		*v = VolumeSizeLimits{}
+	}
+	(*v)[parts[0]] = limits
+	return nil
+}
+
+func (v *VolumeSizeLimits) String() string {
+	return fmt.Sprintf("%v", map[string]SizeLimits(*v))
+}
+
+var _ flag.Value = &VolumeSizeLimits{}
+
 // StringArray is a flag.Value implementation that allows to specify
 // a comma-separated list of strings on the command line.
 type StringArray []string
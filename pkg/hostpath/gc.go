@@ -0,0 +1,215 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hostpath
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	"github.com/kubernetes-csi/csi-driver-host-path/pkg/state"
+)
+
+// Reasons why the garbage collector deleted a snapshot, used as metrics label.
+const (
+	gcReasonOrphaned = "orphaned"
+	gcReasonMaxAge   = "max_age"
+	gcReasonMaxCount = "max_count"
+
+	// gcReasonOrphanedArtifact is used for other files and directories
+	// in the state directory which belong to no snapshot or volume.
+	// They are reported by name and not counted as deleted snapshots.
+	gcReasonOrphanedArtifact = "orphaned_artifact"
+)
+
+// removeSnapshot deletes the snapshot file and the snapshot. Failing to
+// remove the file is not an error: the snapshot garbage collector
+// finds the file again because it has no snapshot anymore.
+func (hp *hostPath) removeSnapshot(snapshotID string) error {
+	if err := hp.unlinkChangedBlocks(snapshotID); err != nil {
+		return err
+	}
+	path := hp.getSnapshotPath(snapshotID)
+	if err := os.RemoveAll(path); err != nil {
+		klog.Warningf("failed to remove snapshot file %s, leaving it to garbage collection: %v", path, err)
+	}
+	return hp.state.DeleteSnapshot(snapshotID)
+}
+
+// runSnapshotGC periodically collects snapshot garbage until stopped.
+func (hp *hostPath) runSnapshotGC(interval time.Duration, stopCh <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+			hp.mutex.Lock()
+			hp.collectSnapshotGarbage(time.Now())
+			hp.mutex.Unlock()
+		}
+	}
+}
+
+// collectSnapshotGarbage removes snapshot files without a snapshot and
+// the other artifacts which a crash may leave behind, and enforces the
+// snapshot retention policy. It returns the IDs of the snapshots and the
+// names of the artifacts that it deleted, by reason.
+func (hp *hostPath) collectSnapshotGarbage(now time.Time) map[string][]string {
+	deleted := map[string][]string{}
+	record := func(reason, snapshotID string) {
+		klog.Infof("snapshot garbage collection: deleted snapshot %s (%s)", snapshotID, reason)
+		snapshotsCollected.WithLabelValues(reason).Inc()
+		deleted[reason] = append(deleted[reason], snapshotID)
+	}
+
+	for _, snapshotID := range hp.expiredSnapshots(now, gcReasonMaxAge) {
+		if err := hp.removeSnapshot(snapshotID); err != nil {
+			klog.Errorf("snapshot garbage collection: failed to delete snapshot %s: %v", snapshotID, err)
+			continue
+		}
+		record(gcReasonMaxAge, snapshotID)
+	}
+	for _, snapshotID := range hp.expiredSnapshots(now, gcReasonMaxCount) {
+		if err := hp.removeSnapshot(snapshotID); err != nil {
+			klog.Errorf("snapshot garbage collection: failed to delete snapshot %s: %v", snapshotID, err)
+			continue
+		}
+		record(gcReasonMaxCount, snapshotID)
+	}
+
+	// Retention may have left files behind, so check for
+	// orphaned files last.
+	known := map[string]bool{}
+	for _, snapshot := range hp.state.GetSnapshots() {
+		known[snapshot.Id] = true
+	}
+	files, err := filepath.Glob(filepath.Join(hp.config.StateDir, "*"+snapshotExt))
+	if err != nil {
+		klog.Errorf("snapshot garbage collection: failed to list snapshot files: %v", err)
+		return deleted
+	}
+	for _, file := range files {
+		snapshotID := strings.TrimSuffix(filepath.Base(file), snapshotExt)
+		if known[snapshotID] {
+			continue
+		}
+		if err := os.RemoveAll(file); err != nil {
+			klog.Errorf("snapshot garbage collection: failed to remove orphaned snapshot file %s: %v", file, err)
+			continue
+		}
+		record(gcReasonOrphaned, snapshotID)
+	}
+
+	artifacts, err := hp.orphanedArtifacts(known)
+	if err != nil {
+		klog.Errorf("snapshot garbage collection: failed to list artifacts: %v", err)
+		return deleted
+	}
+	for _, artifact := range artifacts {
+		if err := os.RemoveAll(artifact); err != nil {
+			klog.Errorf("snapshot garbage collection: failed to remove orphaned %s: %v", artifact, err)
+			continue
+		}
+		klog.Infof("snapshot garbage collection: removed orphaned %s", artifact)
+		name := filepath.Base(artifact)
+		deleted[gcReasonOrphanedArtifact] = append(deleted[gcReasonOrphanedArtifact], name)
+	}
+	return deleted
+}
+
+// orphanedArtifacts returns the paths of the changed block bitmaps whose
+// snapshot is gone, of the snapshot extractions which no volume uses and
+// of incomplete extractions. Because the caller holds the mutex, no
+// extraction can be in progress.
+func (hp *hostPath) orphanedArtifacts(knownSnapshots map[string]bool) ([]string, error) {
+	entries, err := os.ReadDir(hp.config.StateDir)
+	if err != nil {
+		return nil, err
+	}
+	usedDirs := map[string]bool{}
+	for _, vol := range hp.state.GetVolumes() {
+		usedDirs[vol.OverlayLowerDir] = true
+		usedDirs[vol.SharedSnapshotDir] = true
+	}
+
+	var artifacts []string
+	for _, entry := range entries {
+		path := filepath.Join(hp.config.StateDir, entry.Name())
+		base, ext := splitExt(entry.Name())
+		switch ext {
+		case changedBlocksExt:
+			if !knownSnapshots[base] {
+				artifacts = append(artifacts, path)
+			}
+		case overlayLowerExt, sharedSnapshotExt:
+			if !usedDirs[path] {
+				artifacts = append(artifacts, path)
+			}
+		case extractionTmpExt:
+			if _, ext := splitExt(base); ext == overlayLowerExt || ext == sharedSnapshotExt {
+				artifacts = append(artifacts, path)
+			}
+		}
+	}
+	return artifacts, nil
+}
+
+func splitExt(name string) (string, string) {
+	ext := filepath.Ext(name)
+	return strings.TrimSuffix(name, ext), ext
+}
+
+// expiredSnapshots returns the snapshots which violate the retention policy
+// for the given reason. Members of a group snapshot are never expired
+// because they can only be deleted together with their group.
+func (hp *hostPath) expiredSnapshots(now time.Time, reason string) []string {
+	bySource := map[string][]state.Snapshot{}
+	for _, snapshot := range hp.state.GetSnapshots() {
+		if snapshot.GroupSnapshotID != "" {
+			continue
+		}
+		bySource[snapshot.VolID] = append(bySource[snapshot.VolID], snapshot)
+	}
+
+	var expired []string
+	for _, snapshots := range bySource {
+		// Newest first.
+		sort.Slice(snapshots, func(i, j int) bool {
+			return snapshots[i].CreationTime.AsTime().After(snapshots[j].CreationTime.AsTime())
+		})
+		for i, snapshot := range snapshots {
+			switch reason {
+			case gcReasonMaxAge:
+				if hp.config.SnapshotMaxAge > 0 && now.Sub(snapshot.CreationTime.AsTime()) > hp.config.SnapshotMaxAge {
+					expired = append(expired, snapshot.Id)
+				}
+			case gcReasonMaxCount:
+				if hp.config.SnapshotMaxCount > 0 && i >= hp.config.SnapshotMaxCount {
+					expired = append(expired, snapshot.Id)
+				}
+			}
+		}
+	}
+	sort.Strings(expired)
+	return expired
+}
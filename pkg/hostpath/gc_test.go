@@ -0,0 +1,200 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hostpath
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/kubernetes-csi/csi-driver-host-path/pkg/state"
+)
+
+func TestCollectSnapshotGarbage(t *testing.T) {
+	now := time.Now()
+	snapshot := func(id, volID string, age time.Duration) state.Snapshot {
+		return state.Snapshot{
+			Id:           id,
+			Name:         id + "-name",
+			VolID:        volID,
+			CreationTime: timestamppb.New(now.Add(-age)),
+			ReadyToUse:   true,
+		}
+	}
+
+	testCases := []struct {
+		name        string
+		maxAge      time.Duration
+		maxCount    int
+		snapshots   []state.Snapshot
+		orphans     []string
+		artifacts   []string
+		usedDirs    []string
+		wantDeleted map[string][]string
+		wantKept    []string
+	}{
+		{
+			name: "nothing to do",
+			snapshots: []state.Snapshot{
+				snapshot("snap-1", "vol-1", time.Hour),
+			},
+			wantDeleted: map[string][]string{},
+			wantKept:    []string{"snap-1"},
+		},
+		{
+			name: "orphaned files",
+			snapshots: []state.Snapshot{
+				snapshot("snap-1", "vol-1", time.Hour),
+			},
+			orphans: []string{"orphan-1", "orphan-2"},
+			wantDeleted: map[string][]string{
+				gcReasonOrphaned: {"orphan-1", "orphan-2"},
+			},
+			wantKept: []string{"snap-1"},
+		},
+		{
+			name: "orphaned artifacts",
+			snapshots: []state.Snapshot{
+				snapshot("snap-1", "vol-1", time.Hour),
+			},
+			artifacts: []string{
+				"snap-1.cbt",
+				"gone.cbt",
+				"snap-1.lower",
+				"snap-1.shared",
+				"snap-1.lower.tmp",
+				"snap-1.shared.tmp",
+			},
+			usedDirs: []string{"snap-1.lower"},
+			wantDeleted: map[string][]string{
+				gcReasonOrphanedArtifact: {"gone.cbt", "snap-1.lower.tmp", "snap-1.shared", "snap-1.shared.tmp"},
+			},
+			wantKept: []string{"snap-1"},
+		},
+		{
+			name:   "max age",
+			maxAge: 2 * time.Hour,
+			snapshots: []state.Snapshot{
+				snapshot("snap-1", "vol-1", time.Hour),
+				snapshot("snap-2", "vol-1", 3*time.Hour),
+				snapshot("snap-3", "vol-2", 4*time.Hour),
+			},
+			wantDeleted: map[string][]string{
+				gcReasonMaxAge: {"snap-2", "snap-3"},
+			},
+			wantKept: []string{"snap-1"},
+		},
+		{
+			name:     "max count per source volume",
+			maxCount: 2,
+			snapshots: []state.Snapshot{
+				snapshot("snap-1", "vol-1", time.Hour),
+				snapshot("snap-2", "vol-1", 3*time.Hour),
+				snapshot("snap-3", "vol-1", 2*time.Hour),
+				snapshot("snap-4", "vol-2", 4*time.Hour),
+			},
+			wantDeleted: map[string][]string{
+				gcReasonMaxCount: {"snap-2"},
+			},
+			wantKept: []string{"snap-1", "snap-3", "snap-4"},
+		},
+		{
+			name:   "group snapshot members are kept",
+			maxAge: time.Minute,
+			snapshots: []state.Snapshot{
+				func() state.Snapshot {
+					s := snapshot("snap-1", "vol-1", time.Hour)
+					s.GroupSnapshotID = "group-1"
+					return s
+				}(),
+			},
+			wantDeleted: map[string][]string{},
+			wantKept:    []string{"snap-1"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := Config{
+				StateDir:         t.TempDir(),
+				Endpoint:         "unix://tmp/csi.sock",
+				DriverName:       "hostpath.csi.k8s.io",
+				NodeID:           "fakeNodeID",
+				SnapshotMaxAge:   tc.maxAge,
+				SnapshotMaxCount: tc.maxCount,
+			}
+			hp, err := NewHostPathDriver(cfg)
+			require.NoError(t, err)
+			for _, snap := range tc.snapshots {
+				snap.Path = hp.getSnapshotPath(snap.Id)
+				require.NoError(t, os.WriteFile(snap.Path, nil, 0600))
+				require.NoError(t, hp.state.UpdateSnapshot(snap))
+			}
+			for _, orphan := range tc.orphans {
+				require.NoError(t, os.WriteFile(hp.getSnapshotPath(orphan), nil, 0600))
+			}
+			for _, artifact := range tc.artifacts {
+				path := filepath.Join(cfg.StateDir, artifact)
+				if filepath.Ext(artifact) == changedBlocksExt {
+					require.NoError(t, os.WriteFile(path, nil, 0600))
+				} else {
+					require.NoError(t, os.MkdirAll(filepath.Join(path, "data"), 0750))
+				}
+			}
+			for i, dir := range tc.usedDirs {
+				require.NoError(t, hp.state.UpdateVolume(state.Volume{
+					VolID:           fmt.Sprintf("vol-%d", i),
+					VolName:         fmt.Sprintf("vol-%d-name", i),
+					OverlayLowerDir: filepath.Join(cfg.StateDir, dir),
+				}))
+			}
+
+			deleted := hp.collectSnapshotGarbage(now)
+			assert.Equal(t, tc.wantDeleted, deleted)
+
+			var kept []string
+			for _, snap := range hp.state.GetSnapshots() {
+				kept = append(kept, snap.Id)
+				assert.FileExists(t, hp.getSnapshotPath(snap.Id))
+			}
+			assert.ElementsMatch(t, tc.wantKept, kept)
+			for reason, ids := range deleted {
+				for _, id := range ids {
+					if reason == gcReasonOrphanedArtifact {
+						_, err := os.Stat(filepath.Join(cfg.StateDir, id))
+						assert.True(t, os.IsNotExist(err), "%s must have been removed", id)
+						continue
+					}
+					assert.NoFileExists(t, hp.getSnapshotPath(id))
+				}
+			}
+			for _, artifact := range tc.artifacts {
+				if !slices.Contains(deleted[gcReasonOrphanedArtifact], artifact) {
+					_, err := os.Stat(filepath.Join(cfg.StateDir, artifact))
+					assert.NoError(t, err, "%s must have been kept", artifact)
+				}
+			}
+		})
+	}
+}
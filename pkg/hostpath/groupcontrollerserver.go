@@ -17,7 +17,8 @@ limitations under the License.
 package hostpath
 
 import (
-	"os"
+	"maps"
+	"sync"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/pborman/uuid"
@@ -42,7 +43,7 @@ func (hp *hostPath) GroupControllerGetCapabilities(context.Context, *csi.GroupCo
 	}, nil
 }
 
-func (hp *hostPath) CreateVolumeGroupSnapshot(ctx context.Context, req *csi.CreateVolumeGroupSnapshotRequest) (*csi.CreateVolumeGroupSnapshotResponse, error) {
+func (hp *hostPath) CreateVolumeGroupSnapshot(ctx context.Context, req *csi.CreateVolumeGroupSnapshotRequest) (resp *csi.CreateVolumeGroupSnapshotResponse, finalErr error) {
 	if err := hp.validateGroupControllerServiceRequest(csi.GroupControllerServiceCapability_RPC_CREATE_DELETE_GET_VOLUME_GROUP_SNAPSHOT); err != nil {
 		klog.V(3).Infof("invalid create volume group snapshot req: %v", req)
 		return nil, err
@@ -70,6 +71,9 @@ func (hp *hostPath) CreateVolumeGroupSnapshot(ctx context.Context, req *csi.Crea
 		if !exGS.MatchesSourceVolumeIDs(req.GetSourceVolumeIds()) {
 			return nil, status.Errorf(codes.AlreadyExists, "group snapshot with the same name: %s but with different SourceVolumeIds already exist", req.GetName())
 		}
+		if !exGS.MatchesParameters(req.GetParameters()) {
+			return nil, status.Errorf(codes.AlreadyExists, "group snapshot with the same name: %s but with different parameters already exist", req.GetName())
+		}
 
 		// same groupsnapshot has been created.
 		snapshots := make([]*csi.Snapshot, len(exGS.SnapshotIDs))
@@ -103,6 +107,12 @@ func (hp *hostPath) CreateVolumeGroupSnapshot(ctx context.Context, req *csi.Crea
 		}, nil
 	}
 
+	// An empty map distinguishes group snapshots without parameters
+	// from those whose parameters are unknown.
+	parameters := maps.Clone(req.GetParameters())
+	if parameters == nil {
+		parameters = map[string]string{}
+	}
 	groupSnapshot := state.GroupSnapshot{
 		Name:            req.GetName(),
 		Id:              uuid.NewUUID().String(),
@@ -110,50 +120,144 @@ func (hp *hostPath) CreateVolumeGroupSnapshot(ctx context.Context, req *csi.Crea
 		SnapshotIDs:     make([]string, len(req.GetSourceVolumeIds())),
 		SourceVolumeIDs: make([]string, len(req.GetSourceVolumeIds())),
 		ReadyToUse:      true,
+		Parameters:      parameters,
 	}
 
 	copy(groupSnapshot.SourceVolumeIDs, req.GetSourceVolumeIds())
 
-	snapshots := make([]*csi.Snapshot, len(req.GetSourceVolumeIds()))
-
-	// TODO: defer a cleanup function to remove snapshots in case of a failure
-
+	// Check all source volumes before creating any snapshot.
+	volumes := make([]state.Volume, len(req.GetSourceVolumeIds()))
 	for i, volumeID := range req.GetSourceVolumeIds() {
 		hostPathVolume, err := hp.state.GetVolumeByID(volumeID)
 		if err != nil {
 			return nil, err
 		}
+		volumes[i] = hostPathVolume
+	}
+
+	blockSize, blockMetadataType, err := snapshotMetadataFromParameters(req.Parameters)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid volume group snapshot class parameters: %s", err.Error())
+	}
 
+	// The options apply to each member separately because they
+	// depend on the access type of its volume. They are all checked
+	// before any volume gets quiesced.
+	members := make([]*memberSnapshot, len(volumes))
+	for i, hostPathVolume := range volumes {
 		opts, err := optionsFromParameters(hostPathVolume, req.Parameters)
 		if err != nil {
 			return nil, status.Errorf(codes.InvalidArgument, "invalid volume group snapshot class parameters: %s", err.Error())
 		}
-
 		snapshotID := uuid.NewUUID().String()
-		file := hp.getSnapshotPath(snapshotID)
+		members[i] = &memberSnapshot{
+			vol:  hostPathVolume,
+			opts: opts,
+			id:   snapshotID,
+			file: hp.getSnapshotPath(snapshotID),
+		}
+	}
 
-		if err := hp.createSnapshotFromVolume(hostPathVolume, file, opts...); err != nil {
-			return nil, err
+	snapshots := make([]*csi.Snapshot, len(req.GetSourceVolumeIds()))
+
+	// Creating the group snapshot is all-or-nothing: if any member
+	// snapshot fails, those that were already created get removed
+	// again, including their files.
+	var createdSnapshotIDs []string
+	defer func() {
+		if finalErr == nil {
+			return
+		}
+		for _, snapshotID := range createdSnapshotIDs {
+			klog.V(4).Infof("rolling back snapshot %s of group snapshot %s", snapshotID, req.GetName())
+			if err := hp.removeSnapshot(snapshotID); err != nil {
+				klog.Errorf("failed to roll back snapshot %s: %v", snapshotID, err)
+			}
+		}
+		if err := hp.state.DeleteGroupSnapshot(groupSnapshot.Id); err != nil {
+			klog.Errorf("failed to roll back group snapshot %s: %v", groupSnapshot.Id, err)
+		}
+	}()
+
+	// Quiesce all source volumes, so that the member snapshots are
+	// consistent with each other. They must get thawed again in all
+	// cases.
+	// The freeze window is only recorded if some volume actually got
+	// quiesced.
+	freezeStartTime := timestamppb.Now()
+	thaw, quiesced, err := hp.quiesceVolumes(volumes)
+	if quiesced {
+		groupSnapshot.FreezeStartTime = freezeStartTime
+	}
+	thawed := false
+	thawVolumes := func() error {
+		if thawed {
+			return nil
 		}
+		thawed = true
+		err := thaw()
+		if groupSnapshot.FreezeStartTime != nil {
+			groupSnapshot.FreezeEndTime = timestamppb.Now()
+			klog.V(4).Infof("source volumes of group snapshot %s were quiesced for %v", req.GetName(),
+				groupSnapshot.FreezeEndTime.AsTime().Sub(groupSnapshot.FreezeStartTime.AsTime()))
+		}
+		return err
+	}
+	defer func() {
+		if err := thawVolumes(); err != nil {
+			klog.Errorf("failed to thaw source volumes of group snapshot %s: %v", req.GetName(), err)
+		}
+	}()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to quiesce source volumes: %v", err)
+	}
+
+	// Files of failed members may be left behind, so all of them
+	// get rolled back.
+	for _, member := range members {
+		createdSnapshotIDs = append(createdSnapshotIDs, member.id)
+	}
+	if err := hp.createMemberSnapshots(ctx, members); err != nil {
+		return nil, err
+	}
+
+	// Everything else only needs the snapshot files, so applications
+	// can continue to write while it is done.
+	if err := thawVolumes(); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to thaw source volumes: %v", err)
+	}
+	if err := hp.checksumMemberSnapshots(ctx, members); err != nil {
+		return nil, err
+	}
 
-		klog.V(4).Infof("create volume snapshot %s", file)
+	for i, member := range members {
+		volumeID := member.vol.VolID
+		klog.V(4).Infof("create volume snapshot %s", member.file)
 		snapshot := state.Snapshot{}
 		snapshot.Name = req.GetName() + "-" + volumeID
-		snapshot.Id = snapshotID
+		snapshot.Id = member.id
 		snapshot.VolID = volumeID
-		snapshot.Path = file
+		snapshot.Path = member.file
 		snapshot.CreationTime = groupSnapshot.CreationTime
-		snapshot.SizeBytes = hostPathVolume.VolSize
+		snapshot.SizeBytes = member.vol.VolSize
 		snapshot.ReadyToUse = true
 		snapshot.GroupSnapshotID = groupSnapshot.Id
+		snapshot.Checksum = member.checksum
+		snapshot.BlockSize = blockSize
+		snapshot.BlockMetadataType = blockMetadataType
+		if err := hp.chargeSnapshot(&snapshot, member.vol.Kind); err != nil {
+			return nil, err
+		}
 
-		hp.state.UpdateSnapshot(snapshot)
+		if err := hp.state.UpdateSnapshot(snapshot); err != nil {
+			return nil, err
+		}
 
-		groupSnapshot.SnapshotIDs[i] = snapshotID
+		groupSnapshot.SnapshotIDs[i] = member.id
 
 		snapshots[i] = &csi.Snapshot{
-			SizeBytes:       hostPathVolume.VolSize,
-			SnapshotId:      snapshotID,
+			SizeBytes:       member.vol.VolSize,
+			SnapshotId:      member.id,
 			SourceVolumeId:  volumeID,
 			CreationTime:    groupSnapshot.CreationTime,
 			ReadyToUse:      true,
@@ -164,6 +268,11 @@ func (hp *hostPath) CreateVolumeGroupSnapshot(ctx context.Context, req *csi.Crea
 	if err := hp.state.UpdateGroupSnapshot(groupSnapshot); err != nil {
 		return nil, err
 	}
+	// The group snapshot is complete at this point. Tracking only
+	// happens afterwards because it releases the mutex.
+	for _, member := range members {
+		hp.trackChangedBlocks(ctx, member.vol, member.id)
+	}
 
 	return &csi.CreateVolumeGroupSnapshotResponse{
 		GroupSnapshot: &csi.VolumeGroupSnapshot{
@@ -175,6 +284,97 @@ func (hp *hostPath) CreateVolumeGroupSnapshot(ctx context.Context, req *csi.Crea
 	}, nil
 }
 
+// memberSnapshot is one member snapshot of a group snapshot that is
+// being created.
+type memberSnapshot struct {
+	vol      state.Volume
+	opts     []string
+	id       string
+	file     string
+	checksum string
+}
+
+// memberChecksum computes the checksum of a member snapshot file. Tests
+// replace it to observe when checksums get computed.
+var memberChecksum = fileChecksum
+
+// createMemberSnapshots creates the snapshot files of all members. It is
+// the only part of creating a group snapshot which has to happen while
+// the source volumes are quiesced.
+func (hp *hostPath) createMemberSnapshots(ctx context.Context, members []*memberSnapshot) error {
+	return hp.forEachMember(ctx, members, func(ctx context.Context, member *memberSnapshot) error {
+		err := hp.createSnapshotFromVolume(ctx, member.vol, member.file, member.opts...)
+		if err != nil && ctx.Err() != nil {
+			err = status.FromContextError(ctx.Err()).Err()
+		}
+		return err
+	})
+}
+
+// checksumMemberSnapshots computes the checksums of all member snapshot
+// files.
+func (hp *hostPath) checksumMemberSnapshots(ctx context.Context, members []*memberSnapshot) error {
+	return hp.forEachMember(ctx, members, func(ctx context.Context, member *memberSnapshot) error {
+		checksum, err := memberChecksum(member.file)
+		if err != nil {
+			return status.Errorf(codes.Internal, "failed to compute checksum of snapshot %s: %v", member.file, err)
+		}
+		member.checksum = checksum
+		return nil
+	})
+}
+
+// forEachMember invokes fn for all members, with at most
+// GroupSnapshotWorkers of them in parallel. fn must not touch the state,
+// which is not safe for concurrent use. It stops at the first failure or
+// when the context gets canceled; that first error is returned.
+func (hp *hostPath) forEachMember(ctx context.Context, members []*memberSnapshot, fn func(context.Context, *memberSnapshot) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	workers := hp.config.GroupSnapshotWorkers
+	if workers < 1 {
+		workers = 1
+	}
+	sem := make(chan struct{}, workers)
+
+	var wg sync.WaitGroup
+	var errMutex sync.Mutex
+	var firstErr error
+	fail := func(err error) {
+		errMutex.Lock()
+		defer errMutex.Unlock()
+		if firstErr == nil {
+			firstErr = err
+			cancel()
+		}
+	}
+
+	for _, member := range members {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-ctx.Done():
+				fail(status.FromContextError(ctx.Err()).Err())
+				return
+			}
+			if err := ctx.Err(); err != nil {
+				fail(status.FromContextError(err).Err())
+				return
+			}
+			if err := fn(ctx, member); err != nil {
+				fail(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	return firstErr
+}
+
 func (hp *hostPath) DeleteVolumeGroupSnapshot(ctx context.Context, req *csi.DeleteVolumeGroupSnapshotRequest) (*csi.DeleteVolumeGroupSnapshotResponse, error) {
 	if err := hp.validateGroupControllerServiceRequest(csi.GroupControllerServiceCapability_RPC_CREATE_DELETE_GET_VOLUME_GROUP_SNAPSHOT); err != nil {
 		klog.V(3).Infof("invalid delete volume group snapshot req: %v", req)
@@ -205,10 +405,7 @@ func (hp *hostPath) DeleteVolumeGroupSnapshot(ctx context.Context, req *csi.Dele
 
 	for _, snapshotID := range groupSnapshot.SnapshotIDs {
 		klog.V(4).Infof("deleting snapshot %s", snapshotID)
-		path := hp.getSnapshotPath(snapshotID)
-		os.RemoveAll(path)
-
-		if err := hp.state.DeleteSnapshot(snapshotID); err != nil {
+		if err := hp.removeSnapshot(snapshotID); err != nil {
 			return nil, err
 		}
 	}
@@ -248,12 +445,25 @@ func (hp *hostPath) GetVolumeGroupSnapshot(ctx context.Context, req *csi.GetVolu
 		return nil, status.Error(codes.InvalidArgument, "Snapshot IDs do not match the GroupSnapshot IDs")
 	}
 
+	// Returning the parameters and the freeze window is not
+	// implemented because the CSI API has no field for group-level
+	// metadata. They are logged for debugging.
+	klog.V(4).Infof("group snapshot %s was created with parameters %v", groupSnapshotID, groupSnapshot.Parameters)
+	if groupSnapshot.FreezeStartTime != nil {
+		klog.V(4).Infof("source volumes of group snapshot %s were quiesced from %v until %v",
+			groupSnapshotID, groupSnapshot.FreezeStartTime.AsTime(), groupSnapshot.FreezeEndTime.AsTime())
+	}
+
+	// A member may have become unusable since the group snapshot
+	// was created because the scrubber found it corrupted.
+	readyToUse := groupSnapshot.ReadyToUse
 	snapshots := make([]*csi.Snapshot, len(groupSnapshot.SnapshotIDs))
 	for i, snapshotID := range groupSnapshot.SnapshotIDs {
 		snapshot, err := hp.state.GetSnapshotByID(snapshotID)
 		if err != nil {
 			return nil, err
 		}
+		readyToUse = readyToUse && snapshot.ReadyToUse
 
 		snapshots[i] = &csi.Snapshot{
 			SizeBytes:       snapshot.SizeBytes,
@@ -270,7 +480,7 @@ func (hp *hostPath) GetVolumeGroupSnapshot(ctx context.Context, req *csi.GetVolu
 			GroupSnapshotId: groupSnapshotID,
 			Snapshots:       snapshots,
 			CreationTime:    groupSnapshot.CreationTime,
-			ReadyToUse:      groupSnapshot.ReadyToUse,
+			ReadyToUse:      readyToUse,
 		},
 	}, nil
 }
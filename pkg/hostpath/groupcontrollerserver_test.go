@@ -0,0 +1,257 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hostpath
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/kubernetes-csi/csi-driver-host-path/pkg/state"
+)
+
+func TestCreateVolumeGroupSnapshot(t *testing.T) {
+	testCases := []struct {
+		name          string
+		volumes       []string
+		brokenVolumes []string
+		workers       int
+		canceled      bool
+		req           *csi.CreateVolumeGroupSnapshotRequest
+		expectErr     bool
+	}{
+		{
+			name:    "success case",
+			volumes: []string{"vol-1", "vol-2", "vol-3"},
+			req: &csi.CreateVolumeGroupSnapshotRequest{
+				Name:            "group",
+				SourceVolumeIds: []string{"vol-1", "vol-2", "vol-3"},
+			},
+		},
+		{
+			name:    "success case - parallel workers",
+			volumes: []string{"vol-1", "vol-2", "vol-3", "vol-4", "vol-5"},
+			workers: 3,
+			req: &csi.CreateVolumeGroupSnapshotRequest{
+				Name:            "group",
+				SourceVolumeIds: []string{"vol-4", "vol-2", "vol-5", "vol-1", "vol-3"},
+			},
+		},
+		{
+			name:    "failure case - unknown source volume",
+			volumes: []string{"vol-1", "vol-2"},
+			req: &csi.CreateVolumeGroupSnapshotRequest{
+				Name:            "group",
+				SourceVolumeIds: []string{"vol-1", "vol-2", "vol-3"},
+			},
+			expectErr: true,
+		},
+		{
+			name:          "failure case - member snapshot fails",
+			volumes:       []string{"vol-1", "vol-2", "vol-3", "vol-4"},
+			brokenVolumes: []string{"vol-3"},
+			req: &csi.CreateVolumeGroupSnapshotRequest{
+				Name:            "group",
+				SourceVolumeIds: []string{"vol-1", "vol-2", "vol-3", "vol-4"},
+			},
+			expectErr: true,
+		},
+		{
+			name:          "failure case - member snapshot fails with parallel workers",
+			volumes:       []string{"vol-1", "vol-2", "vol-3", "vol-4"},
+			brokenVolumes: []string{"vol-2"},
+			workers:       4,
+			req: &csi.CreateVolumeGroupSnapshotRequest{
+				Name:            "group",
+				SourceVolumeIds: []string{"vol-1", "vol-2", "vol-3", "vol-4"},
+			},
+			expectErr: true,
+		},
+		{
+			name:     "failure case - canceled",
+			volumes:  []string{"vol-1", "vol-2"},
+			workers:  2,
+			canceled: true,
+			req: &csi.CreateVolumeGroupSnapshotRequest{
+				Name:            "group",
+				SourceVolumeIds: []string{"vol-1", "vol-2"},
+			},
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := Config{
+				StateDir:             t.TempDir(),
+				Endpoint:             "unix://tmp/csi.sock",
+				DriverName:           "hostpath.csi.k8s.io",
+				NodeID:               "fakeNodeID",
+				MaxVolumeSize:        1024 * 1024 * 1024 * 1024,
+				GroupSnapshotWorkers: tc.workers,
+			}
+			hp, err := NewHostPathDriver(cfg)
+			require.NoError(t, err)
+			for _, volID := range tc.volumes {
+				_, err := hp.createVolume(volID, volID+"-name", 1024, state.MountAccess, false, "")
+				require.NoError(t, err)
+			}
+			for _, volID := range tc.brokenVolumes {
+				require.NoError(t, os.RemoveAll(hp.getVolumePath(volID)))
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tc.canceled {
+				cancel()
+			}
+
+			resp, err := hp.CreateVolumeGroupSnapshot(ctx, tc.req)
+			snapshotFiles, globErr := filepath.Glob(filepath.Join(cfg.StateDir, "*"+snapshotExt))
+			require.NoError(t, globErr)
+			if tc.expectErr {
+				require.Error(t, err)
+				assert.Empty(t, hp.state.GetSnapshots(), "snapshots after failure")
+				assert.Empty(t, hp.state.GetGroupSnapshots(), "group snapshots after failure")
+				assert.Empty(t, snapshotFiles, "snapshot files after failure")
+				return
+			}
+			require.NoError(t, err)
+			assert.Len(t, resp.GetGroupSnapshot().GetSnapshots(), len(tc.req.SourceVolumeIds))
+			for i, snapshot := range resp.GetGroupSnapshot().GetSnapshots() {
+				assert.Equal(t, tc.req.SourceVolumeIds[i], snapshot.GetSourceVolumeId(), "source volume of snapshot #%d", i)
+			}
+			assert.Len(t, hp.state.GetSnapshots(), len(tc.req.SourceVolumeIds))
+			assert.Len(t, hp.state.GetGroupSnapshots(), 1)
+			assert.Len(t, snapshotFiles, len(tc.req.SourceVolumeIds))
+		})
+	}
+}
+
+func TestVolumeGroupSnapshotParameters(t *testing.T) {
+	cfg := Config{
+		StateDir:      t.TempDir(),
+		Endpoint:      "unix://tmp/csi.sock",
+		DriverName:    "hostpath.csi.k8s.io",
+		NodeID:        "fakeNodeID",
+		MaxVolumeSize: 1024 * 1024 * 1024 * 1024,
+	}
+	hp, err := NewHostPathDriver(cfg)
+	require.NoError(t, err)
+	for _, volID := range []string{"vol-1", "vol-2"} {
+		_, err := hp.createVolume(volID, volID+"-name", 1024, state.MountAccess, false, "")
+		require.NoError(t, err)
+	}
+	req := func(parameters map[string]string) *csi.CreateVolumeGroupSnapshotRequest {
+		return &csi.CreateVolumeGroupSnapshotRequest{
+			Name:            "group",
+			SourceVolumeIds: []string{"vol-1", "vol-2"},
+			Parameters:      parameters,
+		}
+	}
+
+	_, err = hp.CreateVolumeGroupSnapshot(context.TODO(), req(map[string]string{ignoreFailedReadParameterName: "ABC"}))
+	assert.Equal(t, codes.InvalidArgument, status.Code(err), "invalid parameters")
+	assert.Empty(t, hp.state.GetGroupSnapshots(), "group snapshots after invalid parameters")
+
+	parameters := map[string]string{ignoreFailedReadParameterName: "true"}
+	resp, err := hp.CreateVolumeGroupSnapshot(context.TODO(), req(parameters))
+	require.NoError(t, err)
+	groupSnapshotID := resp.GetGroupSnapshot().GetGroupSnapshotId()
+	groupSnapshot, err := hp.state.GetGroupSnapshotByID(groupSnapshotID)
+	require.NoError(t, err)
+	assert.Equal(t, parameters, groupSnapshot.Parameters, "persisted parameters")
+
+	resp, err = hp.CreateVolumeGroupSnapshot(context.TODO(), req(parameters))
+	require.NoError(t, err, "same parameters")
+	assert.Equal(t, groupSnapshotID, resp.GetGroupSnapshot().GetGroupSnapshotId())
+
+	_, err = hp.CreateVolumeGroupSnapshot(context.TODO(), req(nil))
+	assert.Equal(t, codes.AlreadyExists, status.Code(err), "different parameters")
+
+	// Without parameters, the group snapshot must not look like one
+	// with unknown parameters.
+	noParameters := req(nil)
+	noParameters.Name = "group-without-parameters"
+	resp, err = hp.CreateVolumeGroupSnapshot(context.TODO(), noParameters)
+	require.NoError(t, err)
+	noParametersSnapshot, err := hp.state.GetGroupSnapshotByID(resp.GetGroupSnapshot().GetGroupSnapshotId())
+	require.NoError(t, err)
+	assert.NotNil(t, noParametersSnapshot.Parameters, "persisted empty parameters")
+	noParameters.Parameters = parameters
+	_, err = hp.CreateVolumeGroupSnapshot(context.TODO(), noParameters)
+	assert.Equal(t, codes.AlreadyExists, status.Code(err), "parameters added")
+}
+
+// failingSnapshotState fails to store snapshots of one volume.
+type failingSnapshotState struct {
+	state.State
+	volID string
+}
+
+func (s failingSnapshotState) UpdateSnapshot(snapshot state.Snapshot) error {
+	if snapshot.VolID == s.volID {
+		return status.Error(codes.Internal, "injected failure")
+	}
+	return s.State.UpdateSnapshot(snapshot)
+}
+
+func TestCreateVolumeGroupSnapshotRollbackChangedBlocks(t *testing.T) {
+	cfg := Config{
+		StateDir:   t.TempDir(),
+		Endpoint:   "unix://tmp/csi.sock",
+		DriverName: "hostpath.csi.k8s.io",
+		NodeID:     "fakeNodeID",
+	}
+	hp, err := NewHostPathDriver(cfg)
+	require.NoError(t, err)
+	volumes := []string{"vol-1", "vol-2"}
+	for _, volID := range volumes {
+		vol := state.Volume{VolID: volID, VolName: volID, VolPath: filepath.Join(cfg.StateDir, volID), VolAccessType: state.BlockAccess}
+		require.NoError(t, os.WriteFile(vol.VolPath, make([]byte, 16*state.BlockSizeBytes), 0600))
+		require.NoError(t, hp.state.UpdateVolume(vol))
+	}
+	// The first group snapshot starts the chains of changed blocks.
+	_, err = hp.CreateVolumeGroupSnapshot(context.TODO(), &csi.CreateVolumeGroupSnapshotRequest{
+		Name:            "group-1",
+		SourceVolumeIds: volumes,
+	})
+	require.NoError(t, err)
+
+	// Only the first member of the second one gets stored.
+	hp.state = failingSnapshotState{State: hp.state, volID: "vol-2"}
+	_, err = hp.CreateVolumeGroupSnapshot(context.TODO(), &csi.CreateVolumeGroupSnapshotRequest{
+		Name:            "group-2",
+		SourceVolumeIds: volumes,
+	})
+	require.Error(t, err)
+
+	files, err := filepath.Glob(filepath.Join(cfg.StateDir, "*"+changedBlocksExt))
+	require.NoError(t, err)
+	assert.Empty(t, files, "changed blocks files after rollback")
+	snapshots, err := filepath.Glob(filepath.Join(cfg.StateDir, "*"+snapshotExt))
+	require.NoError(t, err)
+	assert.Len(t, snapshots, len(volumes), "snapshot files after rollback")
+	assert.Len(t, hp.state.GetSnapshots(), len(volumes), "snapshots after rollback")
+}
@@ -0,0 +1,84 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hostpath
+
+import (
+	"strconv"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// groupSnapshotIDParameterName is the CreateVolume parameter which
+	// marks the new volume as part of restoring the group snapshot with
+	// that ID. The volume must then be restored from a member snapshot
+	// of that group snapshot.
+	groupSnapshotIDParameterName = "groupSnapshotID"
+
+	// groupRestoreCompleteContextKey is reported by ControllerGetVolume
+	// for volumes restored from a group snapshot. It is "true" when
+	// every member snapshot of the group snapshot was restored into a
+	// volume of that group restore.
+	groupRestoreCompleteContextKey = "groupRestoreComplete"
+)
+
+// checkGroupRestore ensures that the content source is a member snapshot
+// of the group snapshot.
+func (hp *hostPath) checkGroupRestore(groupSnapshotID string, source *csi.VolumeContentSource) error {
+	snapshotID := source.GetSnapshot().GetSnapshotId()
+	if snapshotID == "" {
+		return status.Errorf(codes.InvalidArgument, "%q requires a snapshot as volume content source", groupSnapshotIDParameterName)
+	}
+	groupSnapshot, err := hp.state.GetGroupSnapshotByID(groupSnapshotID)
+	if err != nil {
+		return err
+	}
+	for _, id := range groupSnapshot.SnapshotIDs {
+		if id == snapshotID {
+			return nil
+		}
+	}
+	return status.Errorf(codes.InvalidArgument, "snapshot %s is not a member of group snapshot %s", snapshotID, groupSnapshotID)
+}
+
+// groupRestoreContext returns the volume context which describes the
+// group restore that the volume belongs to, nil if it does not belong
+// to any.
+func (hp *hostPath) groupRestoreContext(groupSnapshotID string) map[string]string {
+	if groupSnapshotID == "" {
+		return nil
+	}
+	complete := false
+	if groupSnapshot, err := hp.state.GetGroupSnapshotByID(groupSnapshotID); err == nil {
+		restored := map[string]bool{}
+		for _, vol := range hp.state.GetVolumes() {
+			if vol.GroupSnapshotID == groupSnapshotID {
+				restored[vol.ParentSnapID] = true
+			}
+		}
+		complete = true
+		for _, snapshotID := range groupSnapshot.SnapshotIDs {
+			complete = complete && restored[snapshotID]
+		}
+	}
+	return map[string]string{
+		groupSnapshotIDParameterName:   groupSnapshotID,
+		groupRestoreCompleteContextKey: strconv.FormatBool(complete),
+	}
+}
@@ -0,0 +1,112 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hostpath
+
+import (
+	"context"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/kubernetes-csi/csi-driver-host-path/pkg/state"
+)
+
+func TestGroupRestore(t *testing.T) {
+	cfg := Config{
+		StateDir:      t.TempDir(),
+		Endpoint:      "unix://tmp/csi.sock",
+		DriverName:    "hostpath.csi.k8s.io",
+		NodeID:        "fakeNodeID",
+		MaxVolumeSize: 1024 * 1024 * 1024 * 1024,
+	}
+	hp, err := NewHostPathDriver(cfg)
+	require.NoError(t, err)
+	for _, volID := range []string{"vol-1", "vol-2", "vol-3"} {
+		_, err := hp.createVolume(volID, volID+"-name", 1024, state.MountAccess, false, "")
+		require.NoError(t, err)
+	}
+	groupResp, err := hp.CreateVolumeGroupSnapshot(context.TODO(), &csi.CreateVolumeGroupSnapshotRequest{
+		Name:            "group",
+		SourceVolumeIds: []string{"vol-1", "vol-2"},
+	})
+	require.NoError(t, err)
+	groupSnapshotID := groupResp.GetGroupSnapshot().GetGroupSnapshotId()
+	members := groupResp.GetGroupSnapshot().GetSnapshots()
+	snapResp, err := hp.CreateSnapshot(context.TODO(), &csi.CreateSnapshotRequest{
+		Name:           "single",
+		SourceVolumeId: "vol-3",
+	})
+	require.NoError(t, err)
+
+	restore := func(name, groupSnapshotID, snapshotID string) (*csi.CreateVolumeResponse, error) {
+		req := &csi.CreateVolumeRequest{
+			Name: name,
+			VolumeCapabilities: []*csi.VolumeCapability{{
+				AccessType: &csi.VolumeCapability_Mount{
+					Mount: &csi.VolumeCapability_MountVolume{},
+				},
+				AccessMode: &csi.VolumeCapability_AccessMode{
+					Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+				},
+			}},
+			CapacityRange: &csi.CapacityRange{RequiredBytes: 1024},
+			Parameters:    map[string]string{groupSnapshotIDParameterName: groupSnapshotID},
+		}
+		if snapshotID != "" {
+			req.VolumeContentSource = &csi.VolumeContentSource{
+				Type: &csi.VolumeContentSource_Snapshot{
+					Snapshot: &csi.VolumeContentSource_SnapshotSource{SnapshotId: snapshotID},
+				},
+			}
+		}
+		return hp.CreateVolume(context.TODO(), req)
+	}
+	restoreContext := func(volID string) map[string]string {
+		resp, err := hp.ControllerGetVolume(context.TODO(), &csi.ControllerGetVolumeRequest{VolumeId: volID})
+		require.NoError(t, err)
+		return resp.GetVolume().GetVolumeContext()
+	}
+
+	_, err = restore("no-source", groupSnapshotID, "")
+	assert.Equal(t, codes.InvalidArgument, status.Code(err), "no content source")
+	_, err = restore("not-a-member", groupSnapshotID, snapResp.GetSnapshot().GetSnapshotId())
+	assert.Equal(t, codes.InvalidArgument, status.Code(err), "snapshot not in group")
+	_, err = restore("unknown-group", "no-such-group", members[0].GetSnapshotId())
+	assert.Equal(t, codes.NotFound, status.Code(err), "unknown group snapshot")
+
+	resp1, err := restore("restore-1", groupSnapshotID, members[0].GetSnapshotId())
+	require.NoError(t, err)
+	vol1 := resp1.GetVolume().GetVolumeId()
+	assert.Equal(t, map[string]string{
+		groupSnapshotIDParameterName:   groupSnapshotID,
+		groupRestoreCompleteContextKey: "false",
+	}, restoreContext(vol1), "one member restored")
+
+	_, err = restore("restore-1", "", members[0].GetSnapshotId())
+	assert.Equal(t, codes.AlreadyExists, status.Code(err), "same name without group snapshot")
+
+	resp2, err := restore("restore-2", groupSnapshotID, members[1].GetSnapshotId())
+	require.NoError(t, err)
+	for _, volID := range []string{vol1, resp2.GetVolume().GetVolumeId()} {
+		assert.Equal(t, "true", restoreContext(volID)[groupRestoreCompleteContextKey], "all members restored, volume %s", volID)
+	}
+	assert.Empty(t, restoreContext("vol-1"), "volume not restored from group snapshot")
+}
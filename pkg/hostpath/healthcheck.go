@@ -17,36 +17,32 @@ limitations under the License.
 package hostpath
 
 import (
-	"encoding/json"
 	"fmt"
 	"os"
-	"os/exec"
+	"path/filepath"
+	"strconv"
 	"strings"
 
+	"golang.org/x/sys/unix"
 	"k8s.io/klog/v2"
 	fs "k8s.io/kubernetes/pkg/volume/util/fs"
-)
+	"k8s.io/utils/mount"
 
-const (
-	podVolumeTargetPath       = "/var/lib/kubelet/pods"
-	csiSignOfVolumeTargetPath = "kubernetes.io~csi/pvc"
+	"github.com/kubernetes-csi/csi-driver-host-path/pkg/state"
 )
 
-type MountPointInfo struct {
-	Target              string           `json:"target"`
-	Source              string           `json:"source"`
-	FsType              string           `json:"fstype"`
-	Options             string           `json:"options"`
-	ContainerFileSystem []MountPointInfo `json:"children,omitempty"`
-}
+// mountInfoPath is the mount table of the mount namespace of the driver,
+// which must be the one of the kubelet for the node health checks to work.
+// Tests replace it.
+var mountInfoPath = "/proc/self/mountinfo"
 
-type ContainerFileSystem struct {
-	Children []MountPointInfo `json:"children"`
-}
+// sysBlockPath is where the kernel describes block devices, including
+// the backing files of loop devices. Tests replace it.
+var sysBlockPath = "/sys/block"
 
-type FileSystems struct {
-	Filsystem []ContainerFileSystem `json:"filesystems"`
-}
+// deletedSuffix is appended by the kernel to mount points and backing
+// files of loop devices which were removed while being in use.
+const deletedSuffix = " (deleted)"
 
 func checkPathExist(path string) (bool, error) {
 	_, err := os.Stat(path)
@@ -61,69 +57,197 @@ func checkPathExist(path string) (bool, error) {
 	return true, nil
 }
 
-func parseMountInfo(originalMountInfo []byte) ([]MountPointInfo, error) {
-	fs := FileSystems{
-		Filsystem: make([]ContainerFileSystem, 0),
+// unescapeMountPath reverts the octal escaping of spaces, tabs, newlines
+// and backslashes in the paths of the mount table.
+func unescapeMountPath(path string) string {
+	if !strings.Contains(path, `\`) {
+		return path
 	}
+	var b strings.Builder
+	for i := 0; i < len(path); i++ {
+		if path[i] == '\\' && i+4 <= len(path) {
+			if c, err := strconv.ParseUint(path[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(path[i])
+	}
+	return b.String()
+}
 
-	if err := json.Unmarshal(originalMountInfo, &fs); err != nil {
+// findBindMounts returns the mount points at which the source path is
+// bind-mounted, not including the source path itself. Bind mounts have
+// the same device and root as the mount which contains the source path,
+// with the root extended by the location of the source within that mount.
+func findBindMounts(source string, mounts []mount.MountInfo) ([]string, error) {
+	source, err := filepath.EvalSymlinks(source)
+	if err != nil {
 		return nil, err
 	}
+	var st unix.Stat_t
+	if err := unix.Stat(source, &st); err != nil {
+		return nil, err
+	}
+	major, minor := int(unix.Major(st.Dev)), int(unix.Minor(st.Dev))
 
-	if len(fs.Filsystem) <= 0 {
-		return nil, fmt.Errorf("failed to get mount info")
+	var parent *mount.MountInfo
+	for i := range mounts {
+		m := &mounts[i]
+		if m.Major != major || m.Minor != minor {
+			continue
+		}
+		mountPoint := unescapeMountPath(m.MountPoint)
+		rel, err := filepath.Rel(mountPoint, source)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+			continue
+		}
+		if parent == nil || len(mountPoint) > len(unescapeMountPath(parent.MountPoint)) {
+			parent = m
+		}
+	}
+	if parent == nil {
+		return nil, fmt.Errorf("no mount found for %s", source)
 	}
+	rel, _ := filepath.Rel(unescapeMountPath(parent.MountPoint), source)
+	root := filepath.Join(unescapeMountPath(parent.Root), rel)
+
+	var mountPoints []string
+	for _, m := range mounts {
+		mountPoint := unescapeMountPath(m.MountPoint)
+		if m.Major == major && m.Minor == minor && unescapeMountPath(m.Root) == root && mountPoint != source {
+			mountPoints = append(mountPoints, mountPoint)
+		}
+	}
+	return mountPoints, nil
+}
 
-	return fs.Filsystem[0].Children, nil
+// isStaleMount returns true if the mount point was removed or its
+// filesystem is no longer accessible.
+func isStaleMount(mountPoint string) bool {
+	if strings.HasSuffix(mountPoint, deletedSuffix) {
+		return true
+	}
+	_, err := os.Stat(mountPoint)
+	return os.IsNotExist(err) || mount.IsCorruptedMnt(err)
 }
 
-func checkMountPointExist(volumePath string) (bool, error) {
-	cmdPath, err := exec.LookPath("findmnt")
+// findLoopDevices returns the loop devices which have the file at path as
+// backing file, including those whose backing file was deleted or
+// replaced in the meantime.
+func findLoopDevices(path string) ([]string, error) {
+	backingFiles, err := filepath.Glob(filepath.Join(sysBlockPath, "loop*", "loop", "backing_file"))
 	if err != nil {
-		return false, fmt.Errorf("findmnt not found: %w", err)
+		return nil, err
 	}
+	var devices []string
+	for _, backingFile := range backingFiles {
+		content, err := os.ReadFile(backingFile)
+		if err != nil {
+			// The loop device was detached in the meantime.
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		name := strings.TrimSuffix(string(content), "\n")
+		if name == path || name == path+deletedSuffix {
+			devices = append(devices, filepath.Join("/dev", filepath.Base(filepath.Dir(filepath.Dir(backingFile)))))
+		}
+	}
+	return devices, nil
+}
 
-	out, err := exec.Command(cmdPath, "--json").CombinedOutput()
+// getLoopStatus returns the device and inode of the file which is really
+// attached to a loop device. Tests replace it.
+var getLoopStatus = func(device string) (*unix.LoopInfo64, error) {
+	fd, err := unix.Open(device, unix.O_RDONLY|unix.O_CLOEXEC, 0)
 	if err != nil {
-		klog.V(3).Infof("failed to execute command: %+v", cmdPath)
-		return false, err
+		return nil, err
 	}
+	defer unix.Close(fd)
+	return unix.IoctlLoopGetStatus64(fd)
+}
 
-	if len(out) < 1 {
-		return false, fmt.Errorf("mount point info is nil")
+// checkBlockVolume checks that the backing file of a block volume has not
+// been truncated and that exactly one loop device is attached to it. It
+// returns that loop device, if there is one.
+func checkBlockVolume(vol state.Volume) (string, []string) {
+	var st unix.Stat_t
+	if err := unix.Stat(vol.VolPath, &st); err != nil {
+		return "", []string{fmt.Sprintf("failed to check the backing file %s: %v", vol.VolPath, err)}
+	}
+	var problems []string
+	if st.Size < vol.VolSize {
+		problems = append(problems, fmt.Sprintf("The backing file %s was truncated to %d bytes, below the volume size of %d bytes", vol.VolPath, st.Size, vol.VolSize))
 	}
 
-	mountInfos, err := parseMountInfo([]byte(out))
+	devices, err := findLoopDevices(vol.VolPath)
 	if err != nil {
-		return false, fmt.Errorf("failed to parse the mount infos: %+v", err)
+		return "", append(problems, fmt.Sprintf("failed to find the loop device of %s: %v", vol.VolPath, err))
 	}
-
-	mountInfosOfPod := MountPointInfo{}
-	for _, mountInfo := range mountInfos {
-		if mountInfo.Target == podVolumeTargetPath {
-			mountInfosOfPod = mountInfo
-			break
-		}
+	if len(devices) == 0 {
+		return "", append(problems, fmt.Sprintf("The backing file %s isn't attached to a loop device", vol.VolPath))
 	}
-
-	for _, mountInfo := range mountInfosOfPod.ContainerFileSystem {
-		if !strings.Contains(mountInfo.Source, volumePath) {
-			continue
+	loopDevice := ""
+	for _, device := range devices {
+		info, err := getLoopStatus(device)
+		switch {
+		case err != nil:
+			problems = append(problems, fmt.Sprintf("failed to query the loop device %s: %v", device, err))
+		case info.Device != uint64(st.Dev) || info.Inode != st.Ino:
+			problems = append(problems, fmt.Sprintf("The loop device %s is attached to a deleted or replaced file instead of the backing file %s", device, vol.VolPath))
+		case loopDevice != "":
+			problems = append(problems, fmt.Sprintf("The backing file %s is attached to more than one loop device: %s and %s", vol.VolPath, loopDevice, device))
+		default:
+			loopDevice = device
 		}
+	}
+	return loopDevice, problems
+}
 
-		_, err = os.Stat(mountInfo.Target)
+// checkVolumeMounts compares the mount table with the paths at which the
+// volume is staged and published according to the state. expected
+// contains the published and staged paths of all volumes, which are not
+// reported as unexpected mounts when volumes share their source.
+func checkVolumeMounts(vol state.Volume, source string, mounts []mount.MountInfo, expected map[string]bool) []string {
+	var problems []string
+	for _, path := range vol.Staged {
+		exists, err := checkPathExist(path)
 		if err != nil {
-			if os.IsNotExist(err) {
-				return false, nil
-			}
-
-			return false, err
+			problems = append(problems, err.Error())
+		} else if !exists {
+			problems = append(problems, fmt.Sprintf("The staging path %s doesn't exist", path))
 		}
-
-		return true, nil
+	}
+	// Without a source, which happens when a block volume has no loop
+	// device, there is nothing that could be mounted.
+	if source == "" {
+		return problems
 	}
 
-	return false, nil
+	mountPoints, err := findBindMounts(source, mounts)
+	if err != nil {
+		return append(problems, fmt.Sprintf("failed to find the mounts of %s: %v", source, err))
+	}
+	mounted := map[string]bool{}
+	for _, mountPoint := range mountPoints {
+		switch {
+		case isStaleMount(mountPoint):
+			problems = append(problems, fmt.Sprintf("Stale mount of the volume at %s", strings.TrimSuffix(mountPoint, deletedSuffix)))
+		case vol.Published.Has(mountPoint):
+			mounted[mountPoint] = true
+		case !expected[mountPoint]:
+			problems = append(problems, fmt.Sprintf("Unexpected mount of the volume at %s", mountPoint))
+		}
+	}
+	for _, path := range vol.Published {
+		if !mounted[path] {
+			problems = append(problems, fmt.Sprintf("The volume isn't mounted at %s", path))
+		}
+	}
+	return problems
 }
 
 func (hp *hostPath) checkPVCapacityValid(volID string) (bool, error) {
@@ -169,6 +293,16 @@ func (hp *hostPath) doHealthCheckInControllerSide(volID string) (bool, string) {
 		return false, "The source path of the volume doesn't exist"
 	}
 
+	vol, err := hp.state.GetVolumeByID(volID)
+	if err != nil {
+		return false, err.Error()
+	}
+	if vol.VolAccessType == state.BlockAccess {
+		if _, problems := checkBlockVolume(vol); len(problems) > 0 {
+			return false, strings.Join(problems, "; ")
+		}
+	}
+
 	capValid, err := hp.checkPVCapacityValid(volID)
 	if err != nil {
 		return false, err.Error()
@@ -191,14 +325,46 @@ func (hp *hostPath) doHealthCheckInControllerSide(volID string) (bool, string) {
 }
 
 func (hp *hostPath) doHealthCheckInNodeSide(volID string) (bool, string) {
-	volumePath := hp.getVolumePath(volID)
-	mpExist, err := checkMountPointExist(volumePath)
+	vol, err := hp.state.GetVolumeByID(volID)
 	if err != nil {
 		return false, err.Error()
 	}
 
-	if !mpExist {
-		return false, "The volume isn't mounted"
+	source := vol.VolPath
+	var problems []string
+	if vol.VolAccessType == state.BlockAccess {
+		source, problems = checkBlockVolume(vol)
+	} else {
+		usage, err := hp.getDirectoryUsage(vol)
+		if err != nil {
+			problems = append(problems, err.Error())
+		} else if usage.Bytes > vol.VolSize {
+			problems = append(problems, fmt.Sprintf("The volume uses %d bytes, more than its size of %d bytes", usage.Bytes, vol.VolSize))
+		}
+	}
+
+	mounts, err := mount.ParseMountInfo(mountInfoPath)
+	if err != nil {
+		return false, fmt.Sprintf("failed to read the mount table: %v", err)
+	}
+
+	// Read-only volumes restored from the same shared snapshot are bind
+	// mounts of the same directory, so they are mounts of each other.
+	expected := map[string]bool{}
+	for _, v := range hp.state.GetVolumes() {
+		expected[v.VolPath] = true
+		for _, path := range v.Published {
+			expected[path] = true
+		}
+		for _, path := range v.Staged {
+			expected[path] = true
+		}
+	}
+
+	problems = append(problems, checkVolumeMounts(vol, source, mounts, expected)...)
+	if len(problems) > 0 {
+		klog.V(3).Infof("mount problems of volume %s: %v", volID, problems)
+		return false, strings.Join(problems, "; ")
 	}
 
 	return true, ""
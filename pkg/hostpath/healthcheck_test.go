@@ -17,336 +17,261 @@ limitations under the License.
 package hostpath
 
 import (
+	"fmt"
+	"os"
+	"path/filepath"
 	"regexp"
 	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
-)
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+	"k8s.io/utils/mount"
 
-const (
-	originalMountInfo = `{
-    "filesystems": [
-        {
-            "target": "/",
-            "source": "overlay",
-            "fstype": "overlay",
-            "options": "rw,relatime,lowerdir=/var/lib/docker/overlay2/l/472T4X42Q446EJ2QSUSNNNI7DJ:/var/lib/docker/overlay2/l/RCFJKQWDXJNO26LYOBWHZKGOJK:/var/lib/docker/overlay2/l/L7PB4C6IPA6RQ4SSZWDHCFM74H:/var/lib/docker/overlay2/l/MVHKUEXU6I2CJJOKDIZ56YJ5PI,upperdir=/var/lib/docker/overlay2/910d3fc528c6e7db604b65e4120cac71a35f4be969113bf07181ebf440561222/diff,workdir=/var/lib/docker/overlay2/910d3fc528c6e7db604b65e4120cac71a35f4be969113bf07181ebf440561222/work",
-            "children": [
-                {
-                    "target": "/proc",
-                    "source": "proc",
-                    "fstype": "proc",
-                    "options": "rw,nosuid,nodev,noexec,relatime"
-                },
-                {
-                    "target": "/sys",
-                    "source": "sysfs",
-                    "fstype": "sysfs",
-                    "options": "ro,nosuid,nodev,noexec,relatime",
-                    "children": [
-                        {
-                            "target": "/sys/fs/cgroup",
-                            "source": "tmpfs",
-                            "fstype": "tmpfs",
-                            "options": "rw,nosuid,nodev,noexec,relatime,mode=755",
-                            "children": [
-                                {
-                                    "target": "/sys/fs/cgroup/systemd",
-                                    "source": "cgroup[/kubepods/besteffort/podfc988f20-10dd-11eb-96e3-fa163f04be9d/543493900fd363057933892062d0bc0cc8a54e30d137ec2bb0027977f3ac73ea]",
-                                    "fstype": "cgroup",
-                                    "options": "rw,nosuid,nodev,noexec,relatime,xattr,release_agent=/usr/lib/systemd/systemd-cgroups-agent,name=systemd"
-                                },
-                                {
-                                    "target": "/sys/fs/cgroup/cpu,cpuacct",
-                                    "source": "cgroup[/kubepods/besteffort/podfc988f20-10dd-11eb-96e3-fa163f04be9d/543493900fd363057933892062d0bc0cc8a54e30d137ec2bb0027977f3ac73ea]",
-                                    "fstype": "cgroup",
-                                    "options": "rw,nosuid,nodev,noexec,relatime,cpuacct,cpu"
-                                },
-                                {
-                                    "target": "/sys/fs/cgroup/devices",
-                                    "source": "cgroup[/kubepods/besteffort/podfc988f20-10dd-11eb-96e3-fa163f04be9d/543493900fd363057933892062d0bc0cc8a54e30d137ec2bb0027977f3ac73ea]",
-                                    "fstype": "cgroup",
-                                    "options": "rw,nosuid,nodev,noexec,relatime,devices"
-                                },
-                                {
-                                    "target": "/sys/fs/cgroup/memory",
-                                    "source": "cgroup[/kubepods/besteffort/podfc988f20-10dd-11eb-96e3-fa163f04be9d/543493900fd363057933892062d0bc0cc8a54e30d137ec2bb0027977f3ac73ea]",
-                                    "fstype": "cgroup",
-                                    "options": "rw,nosuid,nodev,noexec,relatime,memory"
-                                },
-                                {
-                                    "target": "/sys/fs/cgroup/pids",
-                                    "source": "cgroup[/kubepods/besteffort/podfc988f20-10dd-11eb-96e3-fa163f04be9d/543493900fd363057933892062d0bc0cc8a54e30d137ec2bb0027977f3ac73ea]",
-                                    "fstype": "cgroup",
-                                    "options": "rw,nosuid,nodev,noexec,relatime,pids"
-                                },
-                                {
-                                    "target": "/sys/fs/cgroup/net_cls,net_prio",
-                                    "source": "cgroup[/kubepods/besteffort/podfc988f20-10dd-11eb-96e3-fa163f04be9d/543493900fd363057933892062d0bc0cc8a54e30d137ec2bb0027977f3ac73ea]",
-                                    "fstype": "cgroup",
-                                    "options": "rw,nosuid,nodev,noexec,relatime,net_prio,net_cls"
-                                },
-                                {
-                                    "target": "/sys/fs/cgroup/perf_event",
-                                    "source": "cgroup[/kubepods/besteffort/podfc988f20-10dd-11eb-96e3-fa163f04be9d/543493900fd363057933892062d0bc0cc8a54e30d137ec2bb0027977f3ac73ea]",
-                                    "fstype": "cgroup",
-                                    "options": "rw,nosuid,nodev,noexec,relatime,perf_event"
-                                },
-                                {
-                                    "target": "/sys/fs/cgroup/freezer",
-                                    "source": "cgroup[/kubepods/besteffort/podfc988f20-10dd-11eb-96e3-fa163f04be9d/543493900fd363057933892062d0bc0cc8a54e30d137ec2bb0027977f3ac73ea]",
-                                    "fstype": "cgroup",
-                                    "options": "rw,nosuid,nodev,noexec,relatime,freezer"
-                                },
-                                {
-                                    "target": "/sys/fs/cgroup/hugetlb",
-                                    "source": "cgroup[/kubepods/besteffort/podfc988f20-10dd-11eb-96e3-fa163f04be9d/543493900fd363057933892062d0bc0cc8a54e30d137ec2bb0027977f3ac73ea]",
-                                    "fstype": "cgroup",
-                                    "options": "rw,nosuid,nodev,noexec,relatime,hugetlb"
-                                },
-                                {
-                                    "target": "/sys/fs/cgroup/cpuset",
-                                    "source": "cgroup[/kubepods/besteffort/podfc988f20-10dd-11eb-96e3-fa163f04be9d/543493900fd363057933892062d0bc0cc8a54e30d137ec2bb0027977f3ac73ea]",
-                                    "fstype": "cgroup",
-                                    "options": "rw,nosuid,nodev,noexec,relatime,cpuset"
-                                },
-                                {
-                                    "target": "/sys/fs/cgroup/blkio",
-                                    "source": "cgroup[/kubepods/besteffort/podfc988f20-10dd-11eb-96e3-fa163f04be9d/543493900fd363057933892062d0bc0cc8a54e30d137ec2bb0027977f3ac73ea]",
-                                    "fstype": "cgroup",
-                                    "options": "rw,nosuid,nodev,noexec,relatime,blkio"
-                                }
-                            ]
-                        }
-                    ]
-                },
-                {
-                    "target": "/csi",
-                    "source": "/dev/vda2[/var/lib/kubelet/plugins/csi-hostpath]",
-                    "fstype": "xfs",
-                    "options": "rw,nodev,noatime,attr2,nobarrier,inode64,noquota"
-                },
-                {
-                    "target": "/csi-data-dir",
-                    "source": "/dev/vda2[/var/lib/csi-hostpath-data]",
-                    "fstype": "xfs",
-                    "options": "rw,nodev,noatime,attr2,nobarrier,inode64,noquota"
-                },
-                {
-                    "target": "/dev",
-                    "source": "devtmpfs",
-                    "fstype": "devtmpfs",
-                    "options": "rw,nosuid,size=3993932k,nr_inodes=998483,mode=755",
-                    "children": [
-                        {
-                            "target": "/dev/shm",
-                            "source": "tmpfs",
-                            "fstype": "tmpfs",
-                            "options": "rw,nosuid,nodev",
-                            "children": [
-                                {
-                                    "target": "/dev/shm",
-                                    "source": "shm",
-                                    "fstype": "tmpfs",
-                                    "options": "rw,nosuid,nodev,noexec,relatime,size=65536k"
-                                }
-                            ]
-                        },
-                        {
-                            "target": "/dev/pts",
-                            "source": "devpts",
-                            "fstype": "devpts",
-                            "options": "rw,nosuid,noexec,relatime,gid=5,mode=620,ptmxmode=000"
-                        },
-                        {
-                            "target": "/dev/hugepages",
-                            "source": "hugetlbfs",
-                            "fstype": "hugetlbfs",
-                            "options": "rw,relatime"
-                        },
-                        {
-                            "target": "/dev/mqueue",
-                            "source": "mqueue",
-                            "fstype": "mqueue",
-                            "options": "rw,relatime"
-                        },
-                        {
-                            "target": "/dev/termination-log",
-                            "source": "/dev/vda2[/var/lib/kubelet/pods/fc988f20-10dd-11eb-96e3-fa163f04be9d/containers/hostpath/d1c9c9bc]",
-                            "fstype": "xfs",
-                            "options": "rw,nodev,noatime,attr2,nobarrier,inode64,noquota"
-                        }
-                    ]
-                },
-                {
-                    "target": "/etc/resolv.conf",
-                    "source": "/dev/vda2[/var/lib/docker/containers/9da684d4bf029592568b82e90a707c82e6a02d81585344bd9107215c937a3abc/resolv.conf]",
-                    "fstype": "xfs",
-                    "options": "rw,nodev,noatime,attr2,nobarrier,inode64,noquota"
-                },
-                {
-                    "target": "/etc/hostname",
-                    "source": "/dev/vda2[/var/lib/docker/containers/9da684d4bf029592568b82e90a707c82e6a02d81585344bd9107215c937a3abc/hostname]",
-                    "fstype": "xfs",
-                    "options": "rw,nodev,noatime,attr2,nobarrier,inode64,noquota"
-                },
-                {
-                    "target": "/etc/hosts",
-                    "source": "/dev/vda2[/var/lib/kubelet/pods/fc988f20-10dd-11eb-96e3-fa163f04be9d/etc-hosts]",
-                    "fstype": "xfs",
-                    "options": "rw,nodev,noatime,attr2,nobarrier,inode64,noquota"
-                },
-                {
-                    "target": "/var/lib/kubelet/pods",
-                    "source": "/dev/vda2[/var/lib/kubelet/pods]",
-                    "fstype": "xfs",
-                    "options": "rw,nodev,noatime,attr2,nobarrier,inode64,noquota",
-                    "children": [
-                        {
-                            "target": "/var/lib/kubelet/pods/b5d6777b-12aa-11eb-96e3-fa163f04be9d/volumes/kubernetes.io~secret/persistent-volume-csi-cinder-node-sa-token-d95br",
-                            "source": "tmpfs",
-                            "fstype": "tmpfs",
-                            "options": "rw,relatime"
-                        },
-                        {
-                            "target": "/var/lib/kubelet/pods/b5d6777b-12aa-11eb-96e3-fa163f04be9d/volumes/kubernetes.io~secret/secret-cinderplugin",
-                            "source": "tmpfs",
-                            "fstype": "tmpfs",
-                            "options": "rw,relatime"
-                        },
-                        {
-                            "target": "/var/lib/kubelet/pods/be4f605f-1293-11eb-96e3-fa163f04be9d/volumes/kubernetes.io~secret/default-token-js9bk",
-                            "source": "tmpfs",
-                            "fstype": "tmpfs",
-                            "options": "rw,relatime"
-                        },
-                        {
-                            "target": "/var/lib/kubelet/pods/bb6265c4-14d5-11eb-96e3-fa163f04be9d/volumes/kubernetes.io~secret/cattle-token-fg68p",
-                            "source": "tmpfs",
-                            "fstype": "tmpfs",
-                            "options": "rw,relatime"
-                        },
-                        {
-                            "target": "/var/lib/kubelet/pods/bb6265c4-14d5-11eb-96e3-fa163f04be9d/volumes/kubernetes.io~secret/cattle-credentials",
-                            "source": "tmpfs",
-                            "fstype": "tmpfs",
-                            "options": "rw,relatime"
-                        },
-                        {
-                            "target": "/var/lib/kubelet/pods/61d7c25c-150b-11eb-96e3-fa163f04be9d/volumes/kubernetes.io~secret/default-token-js9bk",
-                            "source": "tmpfs",
-                            "fstype": "tmpfs",
-                            "options": "rw,relatime"
-                        },
-                        {
-                            "target": "/var/lib/kubelet/pods/3440e8ee-10de-11eb-8895-fa163feebd84/volumes/kubernetes.io~secret/default-token-8vw47",
-                            "source": "tmpfs",
-                            "fstype": "tmpfs",
-                            "options": "rw,relatime"
-                        },
-                        {
-                            "target": "/var/lib/kubelet/pods/3440e8ee-10de-11eb-8895-fa163feebd84/volumes/kubernetes.io~csi/pvc-33d023c7-10de-11eb-8895-fa163feebd84/mount",
-                            "source": "/dev/vda2[/var/lib/csi-hostpath-data/39267558-10de-11eb-8fb9-0a58ac120605]",
-                            "fstype": "xfs",
-                            "options": "rw,noatime,attr2,nobarrier,inode64,noquota"
-                        },
-                        {
-                            "target": "/var/lib/kubelet/pods/6040ee58-0d0c-11eb-96e3-fa163f04be9d/volumes/kubernetes.io~secret/cluster-monitoring-node-exporter-token-lfkrv",
-                            "source": "tmpfs",
-                            "fstype": "tmpfs",
-                            "options": "rw,relatime"
-                        },
-                        {
-                            "target": "/var/lib/kubelet/pods/60433639-0d0c-11eb-96e3-fa163f04be9d/volumes/kubernetes.io~secret/default-token-js9bk",
-                            "source": "tmpfs",
-                            "fstype": "tmpfs",
-                            "options": "rw,relatime"
-                        },
-                        {
-                            "target": "/var/lib/kubelet/pods/60403c28-0d0c-11eb-96e3-fa163f04be9d/volumes/kubernetes.io~secret/default-token-js9bk",
-                            "source": "tmpfs",
-                            "fstype": "tmpfs",
-                            "options": "rw,relatime"
-                        },
-                        {
-                            "target": "/var/lib/kubelet/pods/60459ef2-0d0c-11eb-96e3-fa163f04be9d/volumes/kubernetes.io~secret/flannel-token-j64bp",
-                            "source": "tmpfs",
-                            "fstype": "tmpfs",
-                            "options": "rw,relatime"
-                        },
-                        {
-                            "target": "/var/lib/kubelet/pods/604b15ec-0d0c-11eb-96e3-fa163f04be9d/volumes/kubernetes.io~secret/default-token-js9bk",
-                            "source": "tmpfs",
-                            "fstype": "tmpfs",
-                            "options": "rw,relatime"
-                        },
-                        {
-                            "target": "/var/lib/kubelet/pods/604b08d5-0d0c-11eb-96e3-fa163f04be9d/volumes/kubernetes.io~secret/vksdns-token-d9lnm",
-                            "source": "tmpfs",
-                            "fstype": "tmpfs",
-                            "options": "rw,relatime"
-                        },
-                        {
-                            "target": "/var/lib/kubelet/pods/fc988f20-10dd-11eb-96e3-fa163f04be9d/volumes/kubernetes.io~secret/default-token-8vw47",
-                            "source": "tmpfs",
-                            "fstype": "tmpfs",
-                            "options": "rw,relatime"
-                        },
-                        {
-                            "target": "/var/lib/kubelet/pods/fd31fc4b-10dd-11eb-96e3-fa163f04be9d/volumes/kubernetes.io~secret/csi-provisioner-token-xbdnl",
-                            "source": "tmpfs",
-                            "fstype": "tmpfs",
-                            "options": "rw,relatime"
-                        },
-                        {
-                            "target": "/var/lib/kubelet/pods/b5a70e65-10dd-11eb-96e3-fa163f04be9d/volumes/kubernetes.io~secret/csi-attacher-token-wmm5s",
-                            "source": "tmpfs",
-                            "fstype": "tmpfs",
-                            "options": "rw,relatime"
-                        },
-                        {
-                            "target": "/var/lib/kubelet/pods/fdd58e3d-10dd-11eb-96e3-fa163f04be9d/volumes/kubernetes.io~secret/csi-resizer-token-zpgnc",
-                            "source": "tmpfs",
-                            "fstype": "tmpfs",
-                            "options": "rw,relatime"
-                        },
-                        {
-                            "target": "/var/lib/kubelet/pods/fe7e95e6-10dd-11eb-96e3-fa163f04be9d/volumes/kubernetes.io~secret/csi-snapshotter-token-sz4wc",
-                            "source": "tmpfs",
-                            "fstype": "tmpfs",
-                            "options": "rw,relatime"
-                        },
-                        {
-                            "target": "/var/lib/kubelet/pods/ff4a82b1-10dd-11eb-96e3-fa163f04be9d/volumes/kubernetes.io~secret/default-token-8vw47",
-                            "source": "tmpfs",
-                            "fstype": "tmpfs",
-                            "options": "rw,relatime"
-                        }
-                    ]
-                },
-                {
-                    "target": "/var/lib/kubelet/plugins",
-                    "source": "/dev/vda2[/var/lib/kubelet/plugins]",
-                    "fstype": "xfs",
-                    "options": "rw,nodev,noatime,attr2,nobarrier,inode64,noquota"
-                },
-                {
-                    "target": "/run/secrets/kubernetes.io/serviceaccount",
-                    "source": "tmpfs",
-                    "fstype": "tmpfs",
-                    "options": "ro,relatime"
-                }
-            ]
-        }
-    ]
-}`
+	"github.com/kubernetes-csi/csi-driver-host-path/pkg/state"
 )
 
-func TestParseMountInfo(t *testing.T) {
-	containerFileSystems, err := parseMountInfo([]byte(originalMountInfo))
-	assert.Nil(t, err)
-	assert.NotNil(t, containerFileSystems)
+func TestUnescapeMountPath(t *testing.T) {
+	assert.Equal(t, "/mnt/plain", unescapeMountPath("/mnt/plain"))
+	assert.Equal(t, "/mnt/with space/tab\tand\\", unescapeMountPath(`/mnt/with\040space/tab\011and\134`))
+	assert.Equal(t, `/mnt/invalid\9`, unescapeMountPath(`/mnt/invalid\9`))
+}
+
+func TestCheckVolumeMounts(t *testing.T) {
+	tmpDir := t.TempDir()
+	dir := func(name string) string {
+		path := filepath.Join(tmpDir, name)
+		require.NoError(t, os.MkdirAll(path, 0750))
+		return path
+	}
+	volPath := dir("vol")
+	target := dir("target")
+	spaceTarget := dir("target with space")
+	other := dir("other")
+	staging := dir("staging")
+
+	var st unix.Stat_t
+	require.NoError(t, unix.Stat(volPath, &st))
+	major, minor := int(unix.Major(st.Dev)), int(unix.Minor(st.Dev))
+	rootMount := mount.MountInfo{Major: major, Minor: minor, Root: "/", MountPoint: "/"}
+	bindMount := func(root, mountPoint string) mount.MountInfo {
+		return mount.MountInfo{Major: major, Minor: minor, Root: root, MountPoint: mountPoint}
+	}
+
+	testCases := []struct {
+		name      string
+		vol       state.Volume
+		source    string
+		mounts    []mount.MountInfo
+		expected  []string
+		wantProbs []string
+	}{
+		{
+			name:   "not published",
+			vol:    state.Volume{VolPath: volPath},
+			source: volPath,
+			mounts: []mount.MountInfo{rootMount},
+		},
+		{
+			name:   "published",
+			vol:    state.Volume{VolPath: volPath, Staged: state.Strings{staging}, Published: state.Strings{target, spaceTarget}},
+			source: volPath,
+			mounts: []mount.MountInfo{
+				rootMount,
+				bindMount(volPath, target),
+				bindMount(volPath, strings.ReplaceAll(spaceTarget, " ", `\040`)),
+			},
+		},
+		{
+			name:      "not mounted",
+			vol:       state.Volume{VolPath: volPath, Published: state.Strings{target}},
+			source:    volPath,
+			mounts:    []mount.MountInfo{rootMount},
+			wantProbs: []string{"The volume isn't mounted at " + target},
+		},
+		{
+			name:      "other directory mounted",
+			vol:       state.Volume{VolPath: volPath, Published: state.Strings{target}},
+			source:    volPath,
+			mounts:    []mount.MountInfo{rootMount, bindMount(other, target)},
+			wantProbs: []string{"The volume isn't mounted at " + target},
+		},
+		{
+			name:      "missing staging path",
+			vol:       state.Volume{VolPath: volPath, Staged: state.Strings{filepath.Join(tmpDir, "no-such-staging")}},
+			source:    volPath,
+			mounts:    []mount.MountInfo{rootMount},
+			wantProbs: []string{"The staging path " + filepath.Join(tmpDir, "no-such-staging") + " doesn't exist"},
+		},
+		{
+			name:      "unexpected mount",
+			vol:       state.Volume{VolPath: volPath},
+			source:    volPath,
+			mounts:    []mount.MountInfo{rootMount, bindMount(volPath, other)},
+			wantProbs: []string{"Unexpected mount of the volume at " + other},
+		},
+		{
+			name:     "mount of other volume with same source",
+			vol:      state.Volume{VolPath: volPath},
+			source:   volPath,
+			mounts:   []mount.MountInfo{rootMount, bindMount(volPath, other)},
+			expected: []string{other},
+		},
+		{
+			name:   "stale mounts",
+			vol:    state.Volume{VolPath: volPath, Published: state.Strings{target}},
+			source: volPath,
+			mounts: []mount.MountInfo{
+				rootMount,
+				bindMount(volPath, target+`\040(deleted)`),
+				bindMount(volPath, filepath.Join(tmpDir, "gone")),
+			},
+			wantProbs: []string{
+				"Stale mount of the volume at " + target,
+				"Stale mount of the volume at " + filepath.Join(tmpDir, "gone"),
+				"The volume isn't mounted at " + target,
+			},
+		},
+		{
+			name: "no loop device",
+			vol:  state.Volume{VolPath: volPath, VolAccessType: state.BlockAccess, Published: state.Strings{target}},
+		},
+		{
+			name:      "source not in mount table",
+			vol:       state.Volume{VolPath: volPath, Published: state.Strings{target}},
+			source:    volPath,
+			wantProbs: []string{"failed to find the mounts of " + volPath + ": no mount found for " + volPath},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			expected := map[string]bool{}
+			for _, path := range tc.expected {
+				expected[path] = true
+			}
+			problems := checkVolumeMounts(tc.vol, tc.source, tc.mounts, expected)
+			assert.Equal(t, tc.wantProbs, problems)
+		})
+	}
+}
+
+func TestHealthCheckSharedSnapshotVolumes(t *testing.T) {
+	hp, err := NewHostPathDriver(Config{
+		StateDir:      t.TempDir(),
+		Endpoint:      "unix://tmp/csi.sock",
+		DriverName:    "hostpath.csi.k8s.io",
+		NodeID:        "fakeNodeID",
+		MaxVolumeSize: 1 << 62,
+	})
+	require.NoError(t, err)
+
+	shared := hp.getSharedSnapshotPath("snap")
+	require.NoError(t, os.Mkdir(shared, 0750))
+	var st unix.Stat_t
+	require.NoError(t, unix.Stat(shared, &st))
+	mountInfo := fmt.Sprintf("1 0 %d:%d / / rw - tmpfs tmpfs rw\n", unix.Major(st.Dev), unix.Minor(st.Dev))
+	for i, volID := range []string{"reader-1", "reader-2"} {
+		volPath := hp.getVolumePath(volID)
+		require.NoError(t, os.Mkdir(volPath, 0750))
+		require.NoError(t, hp.state.UpdateVolume(state.Volume{VolID: volID, VolName: volID, VolPath: volPath, VolSize: gib, SharedSnapshotDir: shared}))
+		mountInfo += fmt.Sprintf("%d 1 %d:%d %s %s ro - tmpfs tmpfs ro\n", i+2, unix.Major(st.Dev), unix.Minor(st.Dev), shared, volPath)
+	}
+	defer func(orig string) { mountInfoPath = orig }(mountInfoPath)
+	mountInfoPath = filepath.Join(t.TempDir(), "mountinfo")
+	require.NoError(t, os.WriteFile(mountInfoPath, []byte(mountInfo), 0600))
+
+	for _, volID := range []string{"reader-1", "reader-2"} {
+		healthy, msg := hp.doHealthCheckInNodeSide(volID)
+		assert.True(t, healthy, "volume %s: %s", volID, msg)
+	}
+}
+
+func TestCheckBlockVolume(t *testing.T) {
+	tmpDir := t.TempDir()
+	volPath := filepath.Join(tmpDir, "vol")
+	require.NoError(t, os.WriteFile(volPath, make([]byte, 4096), 0600))
+	var st unix.Stat_t
+	require.NoError(t, unix.Stat(volPath, &st))
+	attached := &unix.LoopInfo64{Device: uint64(st.Dev), Inode: st.Ino}
+	// The original backing file was deleted and volPath is a new file.
+	deleted := &unix.LoopInfo64{Device: uint64(st.Dev), Inode: st.Ino + 1}
+
+	testCases := []struct {
+		name         string
+		volSize      int64
+		backingFiles map[string]string
+		loopStatus   map[string]*unix.LoopInfo64
+		wantDevice   string
+		wantProbs    []string
+	}{
+		{
+			name:         "not attached",
+			volSize:      4096,
+			backingFiles: map[string]string{"loop0": filepath.Join(tmpDir, "other")},
+			wantProbs:    []string{"The backing file " + volPath + " isn't attached to a loop device"},
+		},
+		{
+			name:    "truncated",
+			volSize: 8192,
+			wantProbs: []string{
+				"The backing file " + volPath + " was truncated to 4096 bytes, below the volume size of 8192 bytes",
+				"The backing file " + volPath + " isn't attached to a loop device",
+			},
+		},
+		{
+			name:         "attached",
+			volSize:      4096,
+			backingFiles: map[string]string{"loop0": filepath.Join(tmpDir, "other"), "loop1": volPath},
+			loopStatus:   map[string]*unix.LoopInfo64{"/dev/loop1": attached},
+			wantDevice:   "/dev/loop1",
+		},
+		{
+			name:         "attached to deleted file",
+			volSize:      4096,
+			backingFiles: map[string]string{"loop1": volPath + deletedSuffix},
+			loopStatus:   map[string]*unix.LoopInfo64{"/dev/loop1": deleted},
+			wantProbs:    []string{"The loop device /dev/loop1 is attached to a deleted or replaced file instead of the backing file " + volPath},
+		},
+		{
+			name:         "attached twice",
+			volSize:      4096,
+			backingFiles: map[string]string{"loop1": volPath, "loop2": volPath},
+			loopStatus:   map[string]*unix.LoopInfo64{"/dev/loop1": attached, "/dev/loop2": attached},
+			wantDevice:   "/dev/loop1",
+			wantProbs:    []string{"The backing file " + volPath + " is attached to more than one loop device: /dev/loop1 and /dev/loop2"},
+		},
+		{
+			name:         "query fails",
+			volSize:      4096,
+			backingFiles: map[string]string{"loop1": volPath},
+			wantProbs:    []string{"failed to query the loop device /dev/loop1: no such file or directory"},
+		},
+	}
+
+	defer func(orig func(string) (*unix.LoopInfo64, error)) { getLoopStatus = orig }(getLoopStatus)
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sysBlockPath = t.TempDir()
+			defer func() { sysBlockPath = "/sys/block" }()
+			for device, backingFile := range tc.backingFiles {
+				dir := filepath.Join(sysBlockPath, device, "loop")
+				require.NoError(t, os.MkdirAll(dir, 0750))
+				require.NoError(t, os.WriteFile(filepath.Join(dir, "backing_file"), []byte(backingFile+"\n"), 0600))
+			}
+			getLoopStatus = func(device string) (*unix.LoopInfo64, error) {
+				if info, ok := tc.loopStatus[device]; ok {
+					return info, nil
+				}
+				return nil, unix.ENOENT
+			}
+
+			loopDevice, problems := checkBlockVolume(state.Volume{VolPath: volPath, VolSize: tc.volSize, VolAccessType: state.BlockAccess})
+			assert.Equal(t, tc.wantDevice, loopDevice)
+			assert.Equal(t, tc.wantProbs, problems)
+		})
+	}
 }
 
 func TestFilterVolumeName(t *testing.T) {
@@ -0,0 +1,239 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hostpath
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"k8s.io/klog/v2"
+)
+
+// maxHealthTransitions is the number of condition changes which are
+// remembered per volume.
+const maxHealthTransitions = 10
+
+// healthCheckSide distinguishes the controller and node health checks of
+// a volume. They check different things and are cached separately.
+type healthCheckSide string
+
+const (
+	controllerSide healthCheckSide = "controller"
+	nodeSide       healthCheckSide = "node"
+)
+
+// healthTransition records a change of the condition of a volume.
+type healthTransition struct {
+	Time     time.Time
+	Abnormal bool
+	Message  string
+}
+
+// volumeHealth is the cached result of the latest health check of a
+// volume.
+type volumeHealth struct {
+	Abnormal           bool
+	Message            string
+	LastCheckTime      time.Time
+	LastTransitionTime time.Time
+	// Transitions contains the most recent changes of the condition,
+	// oldest first. The first check counts as a change.
+	Transitions []healthTransition
+}
+
+func (h volumeHealth) condition() *csi.VolumeCondition {
+	return &csi.VolumeCondition{
+		Abnormal: h.Abnormal,
+		Message:  h.Message,
+	}
+}
+
+type healthKey struct {
+	volID string
+	side  healthCheckSide
+}
+
+// healthMonitor caches volume conditions. It has its own mutex so that it
+// can be used with and without holding hp.mutex, but hp.mutex must never
+// be locked while holding the monitor's mutex.
+type healthMonitor struct {
+	mutex   sync.Mutex
+	volumes map[healthKey]*volumeHealth
+}
+
+func newHealthMonitor() *healthMonitor {
+	return &healthMonitor{
+		volumes: map[healthKey]*volumeHealth{},
+	}
+}
+
+// get returns a copy of the cached health of the volume.
+func (m *healthMonitor) get(volID string, side healthCheckSide) (volumeHealth, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	health, ok := m.volumes[healthKey{volID, side}]
+	if !ok {
+		return volumeHealth{}, false
+	}
+	result := *health
+	result.Transitions = slices.Clone(health.Transitions)
+	return result, true
+}
+
+// record stores the result of a health check and logs it if the
+// condition changed.
+func (m *healthMonitor) record(volID string, side healthCheckSide, abnormal bool, message string, now time.Time) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	key := healthKey{volID, side}
+	health, ok := m.volumes[key]
+	switch {
+	case !ok:
+		health = &volumeHealth{}
+		m.volumes[key] = health
+		if abnormal {
+			klog.Warningf("%s condition of volume %s is abnormal: %s", side, volID, message)
+		} else {
+			klog.V(3).Infof("%s condition of volume %s is normal", side, volID)
+		}
+	case health.Abnormal != abnormal || health.Message != message:
+		duration := now.Sub(health.LastTransitionTime).Round(time.Second)
+		switch {
+		case abnormal && health.Abnormal:
+			klog.Warningf("%s condition of volume %s is still abnormal after %s: %s", side, volID, duration, message)
+		case abnormal:
+			klog.Warningf("%s condition of volume %s became abnormal after %s: %s", side, volID, duration, message)
+		default:
+			klog.Infof("%s condition of volume %s became normal after being abnormal for %s: %s", side, volID, duration, health.Message)
+		}
+	default:
+		health.LastCheckTime = now
+		return
+	}
+
+	health.Abnormal = abnormal
+	health.Message = message
+	health.LastCheckTime = now
+	health.LastTransitionTime = now
+	health.Transitions = append(health.Transitions, healthTransition{Time: now, Abnormal: abnormal, Message: message})
+	if len(health.Transitions) > maxHealthTransitions {
+		health.Transitions = slices.Delete(health.Transitions, 0, len(health.Transitions)-maxHealthTransitions)
+	}
+}
+
+// forget removes the cached health of the volume.
+func (m *healthMonitor) forget(volID string, side healthCheckSide) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	delete(m.volumes, healthKey{volID, side})
+}
+
+// prune removes the cached health of all volumes which are not in the
+// given set.
+func (m *healthMonitor) prune(volIDs map[string]bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for key := range m.volumes {
+		if !volIDs[key.volID] {
+			delete(m.volumes, key)
+		}
+	}
+}
+
+// runHealthMonitor periodically checks the health of all volumes until
+// stopCh is closed. The first round runs immediately to fill the cache.
+func (hp *hostPath) runHealthMonitor(interval time.Duration, stopCh <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		hp.checkVolumeHealth()
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// checkVolumeHealth checks the controller side of all volumes and the node
+// side of those which are staged or published. The mutex is only held
+// while checking one volume, so RPCs do not have to wait for a complete
+// round.
+func (hp *hostPath) checkVolumeHealth() {
+	hp.mutex.Lock()
+	volumes := hp.state.GetVolumes()
+	hp.mutex.Unlock()
+
+	volIDs := map[string]bool{}
+	for _, vol := range volumes {
+		volIDs[vol.VolID] = true
+		hp.checkHealthOfVolume(vol.VolID)
+	}
+	hp.health.prune(volIDs)
+}
+
+func (hp *hostPath) checkHealthOfVolume(volID string) {
+	hp.mutex.Lock()
+	defer hp.mutex.Unlock()
+
+	// The volume may have been deleted in the meantime.
+	vol, err := hp.state.GetVolumeByID(volID)
+	if err != nil {
+		return
+	}
+	healthy, msg := hp.doHealthCheckInControllerSide(volID)
+	hp.health.record(volID, controllerSide, !healthy, msg, time.Now())
+	if vol.Published.Empty() && vol.Staged.Empty() {
+		hp.health.forget(volID, nodeSide)
+		return
+	}
+	healthy, msg = hp.doHealthCheckInNodeSide(volID)
+	hp.health.record(volID, nodeSide, !healthy, msg, time.Now())
+}
+
+// getVolumeCondition returns the cached condition of the volume when the
+// health monitor is enabled and has already checked the volume. Otherwise
+// it checks the volume synchronously.
+func (hp *hostPath) getVolumeCondition(volID string, side healthCheckSide) *csi.VolumeCondition {
+	monitored := hp.config.HealthCheckInterval > 0
+	if monitored {
+		if health, ok := hp.health.get(volID, side); ok {
+			return health.condition()
+		}
+	}
+
+	var healthy bool
+	var msg string
+	if side == controllerSide {
+		healthy, msg = hp.doHealthCheckInControllerSide(volID)
+	} else {
+		healthy, msg = hp.doHealthCheckInNodeSide(volID)
+	}
+	if monitored {
+		hp.health.record(volID, side, !healthy, msg, time.Now())
+	}
+	return &csi.VolumeCondition{
+		Abnormal: !healthy,
+		Message:  msg,
+	}
+}
@@ -0,0 +1,124 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hostpath
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kubernetes-csi/csi-driver-host-path/pkg/state"
+)
+
+func TestHealthMonitorRecord(t *testing.T) {
+	m := newHealthMonitor()
+	start := time.Now()
+	at := func(seconds int) time.Time {
+		return start.Add(time.Duration(seconds) * time.Second)
+	}
+
+	_, ok := m.get("vol", controllerSide)
+	assert.False(t, ok, "no health before first check")
+
+	m.record("vol", controllerSide, false, "", at(0))
+	m.record("vol", controllerSide, false, "", at(1))
+	health, ok := m.get("vol", controllerSide)
+	require.True(t, ok)
+	assert.False(t, health.Abnormal)
+	assert.Equal(t, at(1), health.LastCheckTime)
+	assert.Equal(t, at(0), health.LastTransitionTime)
+	assert.Len(t, health.Transitions, 1)
+
+	m.record("vol", controllerSide, true, "broken", at(2))
+	health, _ = m.get("vol", controllerSide)
+	assert.Equal(t, &csi.VolumeCondition{Abnormal: true, Message: "broken"}, health.condition())
+	assert.Equal(t, at(2), health.LastTransitionTime)
+	assert.Equal(t, []healthTransition{
+		{Time: at(0)},
+		{Time: at(2), Abnormal: true, Message: "broken"},
+	}, health.Transitions)
+
+	_, ok = m.get("vol", nodeSide)
+	assert.False(t, ok, "sides are cached separately")
+
+	for i := range 2 * maxHealthTransitions {
+		m.record("vol", controllerSide, true, fmt.Sprintf("broken %d", i), at(3+i))
+	}
+	health, _ = m.get("vol", controllerSide)
+	require.Len(t, health.Transitions, maxHealthTransitions)
+	assert.Equal(t, fmt.Sprintf("broken %d", 2*maxHealthTransitions-1), health.Transitions[maxHealthTransitions-1].Message)
+
+	m.record("other", nodeSide, false, "", at(0))
+	m.prune(map[string]bool{"other": true})
+	_, ok = m.get("vol", controllerSide)
+	assert.False(t, ok, "health of removed volume must be pruned")
+	_, ok = m.get("other", nodeSide)
+	assert.True(t, ok, "health of existing volume must be kept")
+}
+
+func TestCheckVolumeHealth(t *testing.T) {
+	cfg := Config{
+		StateDir:            t.TempDir(),
+		Endpoint:            "unix://tmp/csi.sock",
+		DriverName:          "hostpath.csi.k8s.io",
+		NodeID:              "fakeNodeID",
+		HealthCheckInterval: time.Minute,
+	}
+	hp, err := NewHostPathDriver(cfg)
+	require.NoError(t, err)
+
+	volPath := hp.getVolumePath("vol")
+	require.NoError(t, os.Mkdir(volPath, 0750))
+	require.NoError(t, hp.state.UpdateVolume(state.Volume{
+		VolID:         "vol",
+		VolName:       "vol-name",
+		VolSize:       1,
+		VolPath:       volPath,
+		VolAccessType: state.MountAccess,
+	}))
+	getCondition := func() *csi.VolumeCondition {
+		resp, err := hp.ControllerGetVolume(context.Background(), &csi.ControllerGetVolumeRequest{VolumeId: "vol"})
+		require.NoError(t, err)
+		return resp.Status.VolumeCondition
+	}
+
+	hp.checkVolumeHealth()
+	assert.False(t, getCondition().Abnormal, "volume must be healthy")
+	_, ok := hp.health.get("vol", nodeSide)
+	assert.False(t, ok, "unpublished volume must not be checked on the node side")
+
+	require.NoError(t, os.Remove(volPath))
+	assert.False(t, getCondition().Abnormal, "condition must be served from the cache")
+	hp.checkVolumeHealth()
+	condition := getCondition()
+	assert.True(t, condition.Abnormal, "missing volume must be abnormal")
+	assert.Equal(t, "The source path of the volume doesn't exist", condition.Message)
+	health, ok := hp.health.get("vol", controllerSide)
+	require.True(t, ok)
+	assert.Len(t, health.Transitions, 2)
+
+	require.NoError(t, hp.state.DeleteVolume("vol"))
+	hp.checkVolumeHealth()
+	_, ok = hp.health.get("vol", controllerSide)
+	assert.False(t, ok, "health of deleted volume must be pruned")
+}
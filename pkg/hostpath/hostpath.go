@@ -17,21 +17,24 @@ limitations under the License.
 package hostpath
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path"
 	"path/filepath"
+	"strconv"
 	"sync"
+	"time"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
-	"k8s.io/apimachinery/pkg/api/resource"
 	"k8s.io/klog/v2"
 	"k8s.io/kubernetes/pkg/volume/util/volumepathhandler"
 	utilexec "k8s.io/utils/exec"
+	"k8s.io/utils/mount"
 
 	"github.com/kubernetes-csi/csi-driver-host-path/pkg/state"
 )
@@ -56,6 +59,8 @@ type hostPath struct {
 	csi.UnimplementedGroupControllerServer
 	csi.UnimplementedSnapshotMetadataServer
 	config Config
+	health *healthMonitor
+	usage  *usageCache
 
 	// gRPC calls involving any of the fields below must be serialized
 	// by locking this mutex before starting. Internal helper
@@ -68,6 +73,7 @@ type Config struct {
 	DriverName                    string
 	Endpoint                      string
 	ProxyEndpoint                 string
+	MetricsAddress                string
 	NodeID                        string
 	VendorVersion                 string
 	StateDir                      string
@@ -75,6 +81,10 @@ type Config struct {
 	MaxVolumeSize                 int64
 	AttachLimit                   int64
 	Capacity                      Capacity
+	CapacityDirs                  CapacityDirs
+	CapacityPolicies              CapacityPolicies
+	VolumeSizeLimits              VolumeSizeLimits
+	SnapshotReserves              Capacity
 	Ephemeral                     bool
 	ShowVersion                   bool
 	EnableAttach                  bool
@@ -83,12 +93,25 @@ type Config struct {
 	EnableControllerModifyVolume  bool
 	EnableSnapshotMetadata        bool
 	SnapshotMetadataBlockType     csi.BlockMetadataType
+	SnapshotMetadataBlockSize     int64
+	SnapshotMetadataExtentLimit   int64
+	SnapshotMetadataWorkers       int
 	AcceptedMutableParameterNames StringArray
 	DisableControllerExpansion    bool
 	DisableNodeExpansion          bool
 	MaxVolumeExpansionSizeNode    int64
 	CheckVolumeLifecycle          bool
 	EnableListSnapshots           bool
+	SnapshotScrubInterval         time.Duration
+	SnapshotGCInterval            time.Duration
+	HealthCheckInterval           time.Duration
+	VolumeUsageMaxAge             time.Duration
+	EphemeralTemplateDirs         StringArray
+	SnapshotMaxAge                time.Duration
+	SnapshotMaxCount              int
+	GroupSnapshotWorkers          int
+	QuiescePreHook                string
+	QuiescePostHook               string
 }
 
 var (
@@ -98,6 +121,9 @@ var (
 const (
 	// Extension with which snapshot files will be saved.
 	snapshotExt = ".snap"
+	// Extension of the temporary directory into which
+	// extractSnapshotOnce extracts a snapshot.
+	extractionTmpExt = ".tmp"
 )
 
 func NewHostPathDriver(cfg Config) (*hostPath, error) {
@@ -113,9 +139,46 @@ func NewHostPathDriver(cfg Config) (*hostPath, error) {
 		return nil, errors.New("no driver endpoint provided")
 	}
 
+	if cfg.SnapshotMetadataBlockSize == 0 {
+		cfg.SnapshotMetadataBlockSize = state.BlockSizeBytes
+	}
+	if err := validateBlockSize(cfg.SnapshotMetadataBlockSize); err != nil {
+		return nil, fmt.Errorf("invalid snapshot metadata block size: %w", err)
+	}
+	if cfg.SnapshotMetadataExtentLimit < 0 {
+		return nil, errors.New("snapshot metadata maximum extent length must not be negative")
+	}
+
 	if err := os.MkdirAll(cfg.StateDir, 0750); err != nil {
 		return nil, fmt.Errorf("failed to create dataRoot: %v", err)
 	}
+	for kind := range cfg.CapacityPolicies {
+		if _, ok := cfg.Capacity[kind]; !ok {
+			return nil, fmt.Errorf("provisioning policy configured for kind %q without capacity", kind)
+		}
+	}
+	for kind, reserve := range cfg.SnapshotReserves {
+		capacity, ok := cfg.Capacity[kind]
+		if !ok {
+			return nil, fmt.Errorf("snapshot reserve configured for kind %q without capacity", kind)
+		}
+		if reserve.Cmp(capacity) > 0 {
+			return nil, fmt.Errorf("snapshot reserve %s for kind %q exceeds its capacity %s", reserve.String(), kind, capacity.String())
+		}
+	}
+	for kind := range cfg.VolumeSizeLimits {
+		if _, ok := cfg.Capacity[kind]; !ok {
+			return nil, fmt.Errorf("volume size limits configured for kind %q without capacity", kind)
+		}
+	}
+	for kind, dir := range cfg.CapacityDirs {
+		if _, ok := cfg.Capacity[kind]; !ok {
+			return nil, fmt.Errorf("directory %s configured for kind %q without capacity", dir, kind)
+		}
+		if err := os.MkdirAll(dir, 0750); err != nil {
+			return nil, fmt.Errorf("failed to create directory for kind %q: %v", kind, err)
+		}
+	}
 
 	klog.Infof("Driver: %v ", cfg.DriverName)
 	klog.Infof("Version: %s", cfg.VendorVersion)
@@ -126,12 +189,16 @@ func NewHostPathDriver(cfg Config) (*hostPath, error) {
 	}
 	hp := &hostPath{
 		config: cfg,
+		health: newHealthMonitor(),
+		usage:  newUsageCache(),
 		state:  s,
 	}
 	return hp, nil
 }
 
 func (hp *hostPath) Run(stopCh <-chan os.Signal) error {
+	hp.restoreVolumeMounts()
+
 	s := NewNonBlockingGRPCServer()
 	var sms csi.SnapshotMetadataServer
 	if hp.config.EnableSnapshotMetadata {
@@ -139,6 +206,22 @@ func (hp *hostPath) Run(stopCh <-chan os.Signal) error {
 	}
 	s.Start(hp.config.Endpoint, hp, hp, hp, hp, sms)
 
+	if hp.config.MetricsAddress != "" {
+		go serveMetrics(hp.config.MetricsAddress)
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	if hp.config.SnapshotScrubInterval > 0 {
+		go hp.runSnapshotScrubber(hp.config.SnapshotScrubInterval, done)
+	}
+	if hp.config.SnapshotGCInterval > 0 {
+		go hp.runSnapshotGC(hp.config.SnapshotGCInterval, done)
+	}
+	if hp.config.HealthCheckInterval > 0 {
+		go hp.runHealthMonitor(hp.config.HealthCheckInterval, done)
+	}
+
 	<-stopCh
 	s.Stop()
 
@@ -147,6 +230,11 @@ func (hp *hostPath) Run(stopCh <-chan os.Signal) error {
 
 // getVolumePath returns the canonical path for hostpath volume
 func (hp *hostPath) getVolumePath(volID string) string {
+	// Volumes of a kind with its own directory are not in the state
+	// directory.
+	if vol, err := hp.state.GetVolumeByID(volID); err == nil && vol.VolPath != "" {
+		return vol.VolPath
+	}
 	return filepath.Join(hp.config.StateDir, volID)
 }
 
@@ -167,8 +255,11 @@ func (hp *hostPath) createVolume(volID, name string, cap int64, volAccessType st
 	if hp.config.Capacity.Enabled() {
 		if kind == "" {
 			// Pick some kind with sufficient remaining capacity.
-			for k, c := range hp.config.Capacity {
-				if hp.sumVolumeSizes(k)+cap <= c.Value() {
+			for k := range hp.config.Capacity {
+				if !hp.config.VolumeSizeLimits[k].accepts(cap) {
+					continue
+				}
+				if available, err := hp.availableCapacity(k); err == nil && cap <= available {
 					kind = k
 					break
 				}
@@ -178,17 +269,14 @@ func (hp *hostPath) createVolume(volID, name string, cap int64, volAccessType st
 			// Still nothing?!
 			return nil, status.Errorf(codes.ResourceExhausted, "requested capacity %d of arbitrary storage exceeds all remaining capacity", cap)
 		}
-		used := hp.sumVolumeSizes(kind)
-		available := hp.config.Capacity[kind]
-		if used+cap > available.Value() {
-			return nil, status.Errorf(codes.ResourceExhausted, "requested capacity %d exceeds remaining capacity for %q, %s out of %s already used",
-				cap, kind, resource.NewQuantity(used, resource.BinarySI).String(), available.String())
+		if err := hp.checkCapacity(kind, cap); err != nil {
+			return nil, err
 		}
 	} else if kind != "" {
 		return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("capacity tracking disabled, specifying kind %q is invalid", kind))
 	}
 
-	path := hp.getVolumePath(volID)
+	path := filepath.Join(hp.getKindDir(kind), volID)
 
 	switch volAccessType {
 	case state.MountAccess:
@@ -197,15 +285,12 @@ func (hp *hostPath) createVolume(volID, name string, cap int64, volAccessType st
 			return nil, err
 		}
 	case state.BlockAccess:
-		executor := utilexec.New()
-		size := fmt.Sprintf("%dM", cap/mib)
 		// Create a block file.
 		_, err := os.Stat(path)
 		if err != nil {
 			if os.IsNotExist(err) {
-				out, err := executor.Command("fallocate", "-l", size, path).CombinedOutput()
-				if err != nil {
-					return nil, fmt.Errorf("failed to create block device: %v, %v", err, string(out))
+				if err := createBlockFile(path, cap, hp.config.CapacityPolicies[kind].Thin); err != nil {
+					return nil, fmt.Errorf("failed to create block device: %v", err)
 				}
 			} else {
 				return nil, fmt.Errorf("failed to stat block device: %v, %v", path, err)
@@ -242,6 +327,30 @@ func (hp *hostPath) createVolume(volID, name string, cap int64, volAccessType st
 	return &volume, nil
 }
 
+// createBlockFile creates the file which backs a block volume. For thin
+// provisioning, the file is sparse and disk space only gets allocated
+// when the volume gets written. Otherwise all of it is allocated up front.
+func createBlockFile(path string, size int64, thin bool) error {
+	if !thin {
+		executor := utilexec.New()
+		out, err := executor.Command("fallocate", "-l", strconv.FormatInt(size, 10), path).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("%v, %v", err, string(out))
+		}
+		return nil
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if err := file.Truncate(size); err != nil {
+		file.Close()
+		os.Remove(path)
+		return err
+	}
+	return file.Close()
+}
+
 // deleteVolume deletes the directory for the hostpath volume.
 func (hp *hostPath) deleteVolume(volID string) error {
 	klog.V(4).Infof("starting to delete hostpath volume: %s", volID)
@@ -261,6 +370,19 @@ func (hp *hostPath) deleteVolume(volID string) error {
 		}
 	}
 
+	if vol.OverlayLowerDir != "" {
+		klog.V(4).Infof("tearing down overlay of volume %s", volID)
+		if err := hp.unmountOverlay(vol); err != nil {
+			return err
+		}
+	}
+	if vol.SharedSnapshotDir != "" {
+		klog.V(4).Infof("unmounting shared snapshot of volume %s", volID)
+		if err := unmountVolumePath(vol); err != nil {
+			return err
+		}
+	}
+
 	path := hp.getVolumePath(volID)
 	if err := os.RemoveAll(path); err != nil && !os.IsNotExist(err) {
 		return err
@@ -268,6 +390,13 @@ func (hp *hostPath) deleteVolume(volID string) error {
 	if err := hp.state.DeleteVolume(volID); err != nil {
 		return err
 	}
+	hp.usage.forget(volID)
+	hp.usage.forget(hp.getOverlayPath(volID))
+	for _, dir := range []string{vol.OverlayLowerDir, vol.SharedSnapshotDir} {
+		if dir != "" {
+			hp.releaseSnapshotExtraction(dir)
+		}
+	}
 	klog.V(4).Infof("deleted hostpath volume: %s = %+v", volID, vol)
 	return nil
 }
@@ -281,6 +410,17 @@ func (hp *hostPath) sumVolumeSizes(kind string) (sum int64) {
 	return
 }
 
+// sumSnapshotSizes returns the disk usage of all snapshots of volumes of
+// a kind.
+func (hp *hostPath) sumSnapshotSizes(kind string) (sum int64) {
+	for _, snapshot := range hp.state.GetSnapshots() {
+		if snapshot.Kind == kind {
+			sum += snapshot.DiskUsage
+		}
+	}
+	return
+}
+
 // hostPathIsEmpty is a simple check to determine if the specified hostpath directory
 // is empty or not.
 func hostPathIsEmpty(p string) (bool, error) {
@@ -297,17 +437,33 @@ func hostPathIsEmpty(p string) (bool, error) {
 	return false, err
 }
 
-// loadFromSnapshot populates the given destPath with data from the snapshotID
-func (hp *hostPath) loadFromSnapshot(size int64, snapshotId, destPath string, mode state.AccessType) error {
+// getSnapshotForRestore returns the snapshot with the given ID after checking
+// that it can be used to populate a volume of the given size.
+func (hp *hostPath) getSnapshotForRestore(size int64, snapshotId string) (state.Snapshot, error) {
 	snapshot, err := hp.state.GetSnapshotByID(snapshotId)
 	if err != nil {
-		return err
+		return state.Snapshot{}, err
+	}
+	if snapshot.Corrupted {
+		return state.Snapshot{}, status.Errorf(codes.DataLoss, "snapshot %v is corrupted", snapshotId)
 	}
 	if !snapshot.ReadyToUse {
-		return fmt.Errorf("snapshot %v is not yet ready to use", snapshotId)
+		return state.Snapshot{}, fmt.Errorf("snapshot %v is not yet ready to use", snapshotId)
 	}
 	if snapshot.SizeBytes > size {
-		return status.Errorf(codes.InvalidArgument, "snapshot %v size %v is greater than requested volume size %v", snapshotId, snapshot.SizeBytes, size)
+		return state.Snapshot{}, status.Errorf(codes.InvalidArgument, "snapshot %v size %v is greater than requested volume size %v", snapshotId, snapshot.SizeBytes, size)
+	}
+	if err := verifySnapshot(snapshot); err != nil {
+		return state.Snapshot{}, err
+	}
+	return snapshot, nil
+}
+
+// loadFromSnapshot populates the given destPath with data from the snapshotID
+func (hp *hostPath) loadFromSnapshot(size int64, snapshotId, destPath string, mode state.AccessType) error {
+	snapshot, err := hp.getSnapshotForRestore(size, snapshotId)
+	if err != nil {
+		return err
 	}
 	snapshotPath := snapshot.Path
 
@@ -332,7 +488,7 @@ func (hp *hostPath) loadFromSnapshot(size int64, snapshotId, destPath string, mo
 }
 
 // loadFromVolume populates the given destPath with data from the srcVolumeID
-func (hp *hostPath) loadFromVolume(size int64, srcVolumeId, destPath string, mode state.AccessType) error {
+func (hp *hostPath) loadFromVolume(ctx context.Context, size int64, srcVolumeId, destPath string, mode state.AccessType) error {
 	hostPathVolume, err := hp.state.GetVolumeByID(srcVolumeId)
 	if err != nil {
 		return err
@@ -348,7 +504,7 @@ func (hp *hostPath) loadFromVolume(size int64, srcVolumeId, destPath string, mod
 	case state.MountAccess:
 		return loadFromFilesystemVolume(hostPathVolume, destPath)
 	case state.BlockAccess:
-		return loadFromBlockVolume(hostPathVolume, destPath)
+		return loadFromBlockVolume(ctx, hostPathVolume, destPath)
 	default:
 		return status.Errorf(codes.InvalidArgument, "unknown accessType: %d", mode)
 	}
@@ -363,24 +519,54 @@ func loadFromFilesystemVolume(hostPathVolume state.Volume, destPath string) erro
 
 	// If the source hostpath volume is empty it's a noop and we just move along, otherwise the cp call will fail with a a file stat error DNE
 	if !isEmpty {
-		args := []string{"-a", srcPath + "/.", destPath + "/"}
-		executor := utilexec.New()
-		out, err := executor.Command("cp", args...).CombinedOutput()
+		method, err := copyDirectory(srcPath, destPath)
 		if err != nil {
-			return fmt.Errorf("failed pre-populate data from volume %v: %s: %w", hostPathVolume.VolID, out, err)
+			return fmt.Errorf("failed pre-populate data from volume %v: %w", hostPathVolume.VolID, err)
 		}
+		recordCopyMethod(copyOperationCloneVolume, method, srcPath, destPath)
 	}
 	return nil
 }
 
-func loadFromBlockVolume(hostPathVolume state.Volume, destPath string) error {
+// copyDirectory copies the content of srcPath into destPath and returns
+// how the data was copied.
+func copyDirectory(srcPath, destPath string) (string, error) {
+	executor := utilexec.New()
+	// Try to share the data extents first. Files which were copied
+	// before cp gave up get overwritten by the full copy.
+	args := []string{"-a", "--reflink=always", srcPath + "/.", destPath + "/"}
+	out, err := executor.Command("cp", args...).CombinedOutput()
+	if err == nil {
+		return copyMethodReflink, nil
+	}
+	klog.V(5).Infof("cannot copy %s with reflinks: %v: %s", srcPath, err, out)
+
+	args = []string{"-a", srcPath + "/.", destPath + "/"}
+	out, err = executor.Command("cp", args...).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", out, err)
+	}
+	return copyMethodFull, nil
+}
+
+func loadFromBlockVolume(ctx context.Context, hostPathVolume state.Volume, destPath string) error {
 	srcPath := hostPathVolume.VolPath
+	method, err := fastCopyFile(ctx, srcPath, destPath)
+	switch {
+	case err == nil:
+		recordCopyMethod(copyOperationCloneVolume, method, srcPath, destPath)
+		return nil
+	case !errors.Is(err, errFastCopyUnsupported):
+		return fmt.Errorf("failed pre-populate data from volume %v: %w", hostPathVolume.VolID, err)
+	}
+
 	args := []string{"if=" + srcPath, "of=" + destPath}
 	executor := utilexec.New()
-	out, err := executor.Command("dd", args...).CombinedOutput()
+	out, err := executor.CommandContext(ctx, "dd", args...).CombinedOutput()
 	if err != nil {
 		return fmt.Errorf("failed pre-populate data from volume %v: %w: %s", hostPathVolume.VolID, err, out)
 	}
+	recordCopyMethod(copyOperationCloneVolume, copyMethodFull, srcPath, destPath)
 	return nil
 }
 
@@ -394,11 +580,19 @@ func (hp *hostPath) getAttachCount() int64 {
 	return count
 }
 
-func (hp *hostPath) createSnapshotFromVolume(vol state.Volume, file string, opts ...string) error {
+func (hp *hostPath) createSnapshotFromVolume(ctx context.Context, vol state.Volume, file string, opts ...string) error {
 	var args []string
 	var cmdName string
 	if vol.VolAccessType == state.BlockAccess {
 		klog.V(4).Infof("Creating snapshot of Raw Block Mode Volume")
+		method, err := createSnapshotFromBlockVolume(ctx, vol, file)
+		switch {
+		case err == nil:
+			recordCopyMethod(copyOperationCreateSnapshot, method, vol.VolPath, file)
+			return nil
+		case !errors.Is(err, errFastCopyUnsupported):
+			return fmt.Errorf("failed create snapshot: %w", err)
+		}
 		cmdName = "cp"
 		args = []string{vol.VolPath, file}
 	} else {
@@ -412,10 +606,153 @@ func (hp *hostPath) createSnapshotFromVolume(vol state.Volume, file string, opts
 	}
 	executor := utilexec.New()
 	optsAndArgs := append(opts, args...)
-	out, err := executor.Command(cmdName, optsAndArgs...).CombinedOutput()
+	out, err := executor.CommandContext(ctx, cmdName, optsAndArgs...).CombinedOutput()
 	if err != nil {
 		return fmt.Errorf("failed create snapshot: %w: %s", err, out)
 	}
+	if vol.VolAccessType == state.BlockAccess {
+		recordCopyMethod(copyOperationCreateSnapshot, copyMethodFull, vol.VolPath, file)
+	}
+
+	return nil
+}
+
+// createSnapshotFromBlockVolume creates the snapshot file with fastCopyFile.
+// A file left behind by an earlier, failed attempt gets overwritten. The
+// file is removed again when that fails.
+func createSnapshotFromBlockVolume(ctx context.Context, vol state.Volume, file string) (string, error) {
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+	method, err := fastCopyFile(ctx, vol.VolPath, file)
+	if err != nil {
+		if err2 := os.Remove(file); err2 != nil {
+			klog.Errorf("failed to cleanup snapshot file %s: %v", file, err2)
+		}
+		return "", err
+	}
+	return method, nil
+}
+
+// extractSnapshotOnce extracts the filesystem snapshot into dir unless that
+// was already done earlier. The extraction goes into a temporary directory
+// first, so an existing dir is always complete.
+func extractSnapshotOnce(snapshot state.Snapshot, dir string) error {
+	if _, err := os.Stat(dir); err == nil {
+		klog.V(4).Infof("reusing extracted snapshot %s", dir)
+		return nil
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("failed to check extracted snapshot %s: %w", dir, err)
+	}
+
+	tmp := dir + extractionTmpExt
+	if err := os.RemoveAll(tmp); err != nil {
+		return fmt.Errorf("failed to remove stale %s: %w", tmp, err)
+	}
+	if err := os.MkdirAll(tmp, 0777); err != nil {
+		return fmt.Errorf("failed to create %s: %w", tmp, err)
+	}
+	cmd := []string{"tar", "zxf", snapshot.Path, "-C", tmp}
+	executor := utilexec.New()
+	klog.V(4).Infof("Command Start: %v", cmd)
+	out, err := executor.Command(cmd[0], cmd[1:]...).CombinedOutput()
+	klog.V(4).Infof("Command Finish: %v", string(out))
+	if err != nil {
+		os.RemoveAll(tmp)
+		return fmt.Errorf("failed to extract snapshot %v: %w: %s", snapshot.Id, err, out)
+	}
+	if err := os.Rename(tmp, dir); err != nil {
+		os.RemoveAll(tmp)
+		return fmt.Errorf("failed to rename %s: %w", tmp, err)
+	}
+	return nil
+}
+
+// releaseSnapshotExtraction removes a directory created by
+// extractSnapshotOnce once no volume uses it anymore. Errors are
+// only logged because the directory is not tracked anywhere else.
+func (hp *hostPath) releaseSnapshotExtraction(dir string) {
+	if refs := hp.snapshotExtractionRefs(dir); refs > 0 {
+		klog.V(4).Infof("extracted snapshot %s still used by %d volume(s)", dir, refs)
+		return
+	}
+	klog.V(4).Infof("removing unused extracted snapshot %s", dir)
+	if err := os.RemoveAll(dir); err != nil {
+		klog.Errorf("failed to remove extracted snapshot %s: %v", dir, err)
+	}
+	hp.usage.forget(dir)
+}
 
+// snapshotExtractionRefs counts the volumes which use the directory
+// created by extractSnapshotOnce.
+func (hp *hostPath) snapshotExtractionRefs(dir string) int {
+	refs := 0
+	for _, vol := range hp.state.GetVolumes() {
+		if vol.OverlayLowerDir == dir || vol.SharedSnapshotDir == dir {
+			refs++
+		}
+	}
+	return refs
+}
+
+// newMounter returns the mounter for the mounts at volume paths. Tests
+// replace it.
+var newMounter = func() mount.Interface {
+	return mount.New("")
+}
+
+// restoreVolumeMounts mounts the overlays of clones and the shared
+// snapshots of read-only volumes again. Those mounts only exist in the
+// mount namespace of the driver, so they are gone after a restart of the
+// driver or a reboot of the node. Volumes which are still mounted are
+// skipped.
+func (hp *hostPath) restoreVolumeMounts() {
+	hp.mutex.Lock()
+	defer hp.mutex.Unlock()
+
+	mounter := newMounter()
+	for _, vol := range hp.state.GetVolumes() {
+		var mountVolume func(mount.Interface, state.Volume) error
+		switch {
+		case vol.OverlayLowerDir != "":
+			mountVolume = hp.mountOverlay
+		case vol.SharedSnapshotDir != "":
+			mountVolume = mountSharedSnapshot
+		default:
+			continue
+		}
+		notMnt, err := mount.IsNotMountPoint(mounter, vol.VolPath)
+		if err != nil {
+			klog.Errorf("failed to check mount of volume %s at %s: %v", vol.VolID, vol.VolPath, err)
+			continue
+		}
+		if !notMnt {
+			continue
+		}
+		if err := mountVolume(mounter, vol); err != nil {
+			klog.Errorf("failed to restore mount of volume %s at %s: %v", vol.VolID, vol.VolPath, err)
+			continue
+		}
+		klog.Infof("restored mount of volume %s at %s", vol.VolID, vol.VolPath)
+	}
+}
+
+// unmountVolumePath unmounts whatever is mounted at the volume path,
+// if anything.
+func unmountVolumePath(vol state.Volume) error {
+	mounter := newMounter()
+	notMnt, err := mount.IsNotMountPoint(mounter, vol.VolPath)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("check mount %s: %w", vol.VolPath, err)
+	}
+	if err == nil && !notMnt {
+		if err := mounter.Unmount(vol.VolPath); err != nil {
+			return fmt.Errorf("unmount %s: %w", vol.VolPath, err)
+		}
+	}
 	return nil
 }
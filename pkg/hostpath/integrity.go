@@ -0,0 +1,131 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hostpath
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+
+	"github.com/kubernetes-csi/csi-driver-host-path/pkg/state"
+)
+
+// fileChecksum returns the hex-encoded SHA-256 checksum of the file content.
+func fileChecksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// verifySnapshot compares the content of the snapshot file against the
+// checksum recorded when the snapshot was created. Snapshots without a
+// checksum (created by older driver releases) are accepted as they are.
+//
+// The returned error is suitable as result of a gRPC call.
+func verifySnapshot(snapshot state.Snapshot) error {
+	if snapshot.Checksum == "" {
+		return nil
+	}
+	checksum, err := fileChecksum(snapshot.Path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return status.Errorf(codes.DataLoss, "snapshot %v: archive %s is missing", snapshot.Id, snapshot.Path)
+		}
+		return status.Errorf(codes.Internal, "snapshot %v: failed to compute checksum: %v", snapshot.Id, err)
+	}
+	if checksum != snapshot.Checksum {
+		return status.Errorf(codes.DataLoss, "snapshot %v: checksum mismatch, expected %s, got %s", snapshot.Id, snapshot.Checksum, checksum)
+	}
+	return nil
+}
+
+// runSnapshotScrubber periodically verifies all snapshots until stopped.
+func (hp *hostPath) runSnapshotScrubber(interval time.Duration, stopCh <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+			hp.scrubSnapshots()
+		}
+	}
+}
+
+// scrubSnapshots verifies the checksum of each snapshot which is ready to
+// use and marks those which fail verification as corrupted. Checksums are
+// computed without holding the mutex because that can take a while.
+func (hp *hostPath) scrubSnapshots() {
+	hp.mutex.Lock()
+	snapshots := hp.state.GetSnapshots()
+	hp.mutex.Unlock()
+
+	for _, snapshot := range snapshots {
+		if !snapshot.ReadyToUse || snapshot.Checksum == "" {
+			continue
+		}
+		err := verifySnapshot(snapshot)
+		if err == nil {
+			continue
+		}
+		if status.Code(err) != codes.DataLoss {
+			klog.Errorf("scrubbing snapshot %s failed: %v", snapshot.Id, err)
+			continue
+		}
+		if err := hp.markSnapshotCorrupted(snapshot.Id, err); err != nil {
+			klog.Errorf("failed to mark snapshot %s as corrupted: %v", snapshot.Id, err)
+		}
+	}
+}
+
+// markSnapshotCorrupted marks the snapshot as not ready to use. It is not
+// an error when the snapshot was deleted in the meantime.
+func (hp *hostPath) markSnapshotCorrupted(snapshotID string, reason error) error {
+	hp.mutex.Lock()
+	defer hp.mutex.Unlock()
+
+	snapshot, err := hp.state.GetSnapshotByID(snapshotID)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil
+		}
+		return err
+	}
+	klog.Warningf("snapshot %s is corrupted, marking it as not ready to use: %v", snapshotID, reason)
+	snapshot.ReadyToUse = false
+	snapshot.Corrupted = true
+	if err := hp.state.UpdateSnapshot(snapshot); err != nil {
+		return fmt.Errorf("update snapshot: %w", err)
+	}
+	return nil
+}
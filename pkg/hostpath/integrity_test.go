@@ -0,0 +1,137 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hostpath
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/kubernetes-csi/csi-driver-host-path/pkg/state"
+)
+
+func TestVerifySnapshot(t *testing.T) {
+	testCases := []struct {
+		name     string
+		content  string
+		checksum string
+		missing  bool
+		wantCode codes.Code
+	}{
+		{
+			name:     "no checksum recorded",
+			content:  "data",
+			wantCode: codes.OK,
+		},
+		{
+			name:     "matching checksum",
+			content:  "data",
+			checksum: "3a6eb0790f39ac87c94f3856b2dd2c5d110e6811602261a9a923d3bb23adc8b7",
+			wantCode: codes.OK,
+		},
+		{
+			name:     "truncated file",
+			content:  "dat",
+			checksum: "3a6eb0790f39ac87c94f3856b2dd2c5d110e6811602261a9a923d3bb23adc8b7",
+			wantCode: codes.DataLoss,
+		},
+		{
+			name:     "missing file",
+			missing:  true,
+			checksum: "3a6eb0790f39ac87c94f3856b2dd2c5d110e6811602261a9a923d3bb23adc8b7",
+			wantCode: codes.DataLoss,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "snap"+snapshotExt)
+			if !tc.missing {
+				require.NoError(t, os.WriteFile(path, []byte(tc.content), 0600))
+			}
+
+			err := verifySnapshot(state.Snapshot{Id: "snap", Path: path, Checksum: tc.checksum})
+			assert.Equal(t, tc.wantCode, status.Code(err), "unexpected status code: %v", err)
+		})
+	}
+}
+
+func TestScrubSnapshots(t *testing.T) {
+	stateDir := t.TempDir()
+	cfg := Config{
+		StateDir:   stateDir,
+		Endpoint:   "unix://tmp/csi.sock",
+		DriverName: "hostpath.csi.k8s.io",
+		NodeID:     "fakeNodeID",
+	}
+	hp, err := NewHostPathDriver(cfg)
+	require.NoError(t, err)
+
+	for _, id := range []string{"good", "bad"} {
+		path := hp.getSnapshotPath(id)
+		require.NoError(t, os.WriteFile(path, []byte(id), 0600))
+		checksum, err := fileChecksum(path)
+		require.NoError(t, err)
+		require.NoError(t, hp.state.UpdateSnapshot(state.Snapshot{
+			Id:         id,
+			Name:       id + "-name",
+			VolID:      "volume",
+			Path:       path,
+			SizeBytes:  1,
+			ReadyToUse: true,
+			Checksum:   checksum,
+		}))
+	}
+	require.NoError(t, hp.state.UpdateGroupSnapshot(state.GroupSnapshot{
+		Id:              "group",
+		Name:            "group-name",
+		SnapshotIDs:     []string{"good", "bad"},
+		SourceVolumeIDs: []string{"volume", "volume"},
+		ReadyToUse:      true,
+	}))
+	getGroupReq := &csi.GetVolumeGroupSnapshotRequest{
+		GroupSnapshotId: "group",
+		SnapshotIds:     []string{"good", "bad"},
+	}
+	resp, err := hp.GetVolumeGroupSnapshot(context.Background(), getGroupReq)
+	require.NoError(t, err)
+	assert.True(t, resp.GetGroupSnapshot().GetReadyToUse(), "group snapshot must be ready before corruption")
+	require.NoError(t, os.WriteFile(hp.getSnapshotPath("bad"), []byte("bit rot"), 0600))
+
+	hp.scrubSnapshots()
+
+	good, err := hp.state.GetSnapshotByID("good")
+	require.NoError(t, err)
+	assert.True(t, good.ReadyToUse, "intact snapshot must stay ready")
+	bad, err := hp.state.GetSnapshotByID("bad")
+	require.NoError(t, err)
+	assert.False(t, bad.ReadyToUse, "corrupted snapshot must not be ready")
+	assert.True(t, bad.Corrupted, "corrupted snapshot must be marked")
+	resp, err = hp.GetVolumeGroupSnapshot(context.Background(), getGroupReq)
+	require.NoError(t, err)
+	assert.False(t, resp.GetGroupSnapshot().GetReadyToUse(), "group snapshot with a corrupted member must not be ready")
+
+	err = hp.loadFromSnapshot(1, "bad", t.TempDir(), state.MountAccess)
+	assert.Equal(t, codes.DataLoss, status.Code(err), "unexpected status code: %v", err)
+}
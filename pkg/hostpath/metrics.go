@@ -0,0 +1,71 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hostpath
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"k8s.io/klog/v2"
+)
+
+const metricsNamespace = "hostpath"
+
+var (
+	// metricsRegistry contains all metrics of the driver. It is
+	// only served when a metrics address is configured.
+	metricsRegistry = prometheus.NewRegistry()
+
+	// copyOperations counts how data was copied when cloning
+	// volumes and creating snapshots.
+	copyOperations = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "copy_operations_total",
+			Help:      "Number of data copies, partitioned by operation and by the method that was used.",
+		},
+		[]string{"operation", "method"},
+	)
+
+	// snapshotsCollected counts the snapshots deleted by the
+	// snapshot garbage collector.
+	snapshotsCollected = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "snapshot_gc_deleted_total",
+			Help:      "Number of snapshots deleted by the snapshot garbage collector, partitioned by reason.",
+		},
+		[]string{"reason"},
+	)
+)
+
+func init() {
+	metricsRegistry.MustRegister(copyOperations, snapshotsCollected)
+}
+
+// serveMetrics serves the metrics registry on the given address until
+// the server fails. It is meant to run in its own goroutine.
+func serveMetrics(address string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{}))
+	klog.Infof("Serving metrics on address: %s", address)
+	if err := http.ListenAndServe(address, mux); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		klog.Errorf("Failed to serve metrics: %v", err)
+	}
+}
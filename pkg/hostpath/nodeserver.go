@@ -72,11 +72,8 @@ func (hp *hostPath) NodePublishVolume(ctx context.Context, req *csi.NodePublishV
 		volName := fmt.Sprintf("ephemeral-%s", volID)
 		if _, err := hp.state.GetVolumeByName(volName); err != nil {
 			// Volume doesn't exist, create it
-			kind := req.GetVolumeContext()[storageKind]
-			// Configurable size would be nice. For now we use a small, fixed volume size of 100Mi.
-			volSize := int64(100 * 1024 * 1024)
-			vol, err := hp.createVolume(req.GetVolumeId(), volName, volSize, state.MountAccess, ephemeralVolume, kind)
-			if err != nil && !os.IsExist(err) {
+			vol, err := hp.createEphemeralVolume(volID, volName, req.GetVolumeContext())
+			if err != nil {
 				klog.Error("ephemeral mode failed to create volume: ", err)
 				return nil, err
 			}
@@ -440,32 +437,22 @@ func (hp *hostPath) NodeGetVolumeStats(ctx context.Context, in *csi.NodeGetVolum
 		return nil, status.Errorf(codes.NotFound, "Could not get file information from %s: %+v", in.GetVolumePath(), err)
 	}
 
-	healthy, msg := hp.doHealthCheckInNodeSide(in.GetVolumeId())
-	klog.V(3).Infof("Healthy state: %+v Volume: %+v", volume.VolName, healthy)
-	available, capacity, used, inodes, inodesFree, inodesUsed, err := getPVStats(in.GetVolumePath())
+	condition := hp.getVolumeCondition(in.GetVolumeId(), nodeSide)
+	klog.V(3).Infof("Healthy state: %+v Volume: %+v", volume.VolName, !condition.Abnormal)
+	var usage []*csi.VolumeUsage
+	if volume.VolAccessType == state.BlockAccess {
+		usage, err = getBlockVolumeStats(volume, in.GetVolumePath())
+	} else {
+		usage, err = hp.getDirectoryVolumeStats(volume)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("get volume stats failed: %w", err)
 	}
 
-	klog.V(3).Infof("Capacity: %+v Used: %+v Available: %+v Inodes: %+v Free inodes: %+v Used inodes: %+v", capacity, used, available, inodes, inodesFree, inodesUsed)
+	klog.V(3).Infof("Volume usage: %+v", usage)
 	return &csi.NodeGetVolumeStatsResponse{
-		Usage: []*csi.VolumeUsage{
-			{
-				Available: available,
-				Used:      used,
-				Total:     capacity,
-				Unit:      csi.VolumeUsage_BYTES,
-			}, {
-				Available: inodesFree,
-				Used:      inodesUsed,
-				Total:     inodes,
-				Unit:      csi.VolumeUsage_INODES,
-			},
-		},
-		VolumeCondition: &csi.VolumeCondition{
-			Abnormal: !healthy,
-			Message:  msg,
-		},
+		Usage:           usage,
+		VolumeCondition: condition,
 	}, nil
 }
 
@@ -20,6 +20,9 @@ import (
 	"fmt"
 	"strconv"
 
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"k8s.io/apimachinery/pkg/api/resource"
+
 	"github.com/kubernetes-csi/csi-driver-host-path/pkg/state"
 )
 
@@ -50,3 +53,53 @@ func optionsFromParameters(vol state.Volume, parameters map[string]string) ([]st
 
 	return nil, nil
 }
+
+const (
+	// blockSizeParameterName is the snapshot class parameter which
+	// overrides the block size of the snapshot metadata service for
+	// snapshots of that class. It is a quantity like "64Ki".
+	blockSizeParameterName = "blockSize"
+
+	// blockMetadataTypeParameterName is the snapshot class parameter
+	// which overrides the block metadata type of the snapshot metadata
+	// service, FIXED_LENGTH or VARIABLE_LENGTH.
+	blockMetadataTypeParameterName = "blockMetadataType"
+
+	// minBlockSize is the smallest supported block size.
+	minBlockSize = 512
+)
+
+// validateBlockSize ensures that the block size is a power of two and
+// not smaller than minBlockSize.
+func validateBlockSize(blockSize int64) error {
+	if blockSize < minBlockSize || blockSize&(blockSize-1) != 0 {
+		return fmt.Errorf("block size must be a power of two and at least %d, got %d", minBlockSize, blockSize)
+	}
+	return nil
+}
+
+// snapshotMetadataFromParameters returns the block size and block metadata
+// type requested by the snapshot class parameters. Zero and empty mean
+// that the parameters are not set.
+func snapshotMetadataFromParameters(parameters map[string]string) (int64, string, error) {
+	var blockSize int64
+	if value := parameters[blockSizeParameterName]; value != "" {
+		quantity, err := resource.ParseQuantity(value)
+		if err != nil {
+			return 0, "", fmt.Errorf("invalid value for %q: %w", blockSizeParameterName, err)
+		}
+		blockSize = quantity.Value()
+		if err := validateBlockSize(blockSize); err != nil {
+			return 0, "", fmt.Errorf("invalid value for %q: %w", blockSizeParameterName, err)
+		}
+	}
+
+	blockMetadataType := parameters[blockMetadataTypeParameterName]
+	switch blockMetadataType {
+	case "", csi.BlockMetadataType_FIXED_LENGTH.String(), csi.BlockMetadataType_VARIABLE_LENGTH.String():
+	default:
+		return 0, "", fmt.Errorf("invalid value for %q, expected %s or %s but was %q",
+			blockMetadataTypeParameterName, csi.BlockMetadataType_FIXED_LENGTH, csi.BlockMetadataType_VARIABLE_LENGTH, blockMetadataType)
+	}
+	return blockSize, blockMetadataType, nil
+}
@@ -0,0 +1,145 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hostpath
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"k8s.io/klog/v2"
+	"k8s.io/utils/mount"
+
+	"github.com/kubernetes-csi/csi-driver-host-path/pkg/state"
+)
+
+const (
+	// cloneModeParameterName is the storage class parameter which
+	// selects how filesystem volumes get populated from a snapshot.
+	cloneModeParameterName = "cloneMode"
+
+	// cloneModeCopy extracts the snapshot into the new volume. This is
+	// the default.
+	cloneModeCopy = "copy"
+
+	// cloneModeOverlay mounts an overlayfs at the volume path. The
+	// lower layer is a read-only extraction of the snapshot which is
+	// shared by all volumes cloned from it, the upper layer contains
+	// the changes of each volume.
+	cloneModeOverlay = "overlay"
+
+	// Extensions of the directories for overlayfs clones.
+	overlayLowerExt = ".lower"
+	overlayExt      = ".overlay"
+)
+
+// cloneModeFromParameters returns the clone mode requested by the
+// storage class parameters.
+func cloneModeFromParameters(parameters map[string]string) (string, error) {
+	switch mode := parameters[cloneModeParameterName]; mode {
+	case "":
+		return cloneModeCopy, nil
+	case cloneModeCopy, cloneModeOverlay:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("invalid value for %q, expected one of %q or %q but was %q",
+			cloneModeParameterName, cloneModeCopy, cloneModeOverlay, mode)
+	}
+}
+
+// getOverlayLowerPath returns the path of the read-only extraction of
+// a snapshot which is shared by all overlayfs clones of that snapshot.
+func (hp *hostPath) getOverlayLowerPath(snapshotID string) string {
+	return filepath.Join(hp.config.StateDir, snapshotID+overlayLowerExt)
+}
+
+// getOverlayPath returns the directory with the upper and work
+// directories of an overlayfs clone.
+func (hp *hostPath) getOverlayPath(volID string) string {
+	return filepath.Join(hp.config.StateDir, volID+overlayExt)
+}
+
+// loadFromSnapshotOverlay populates the volume by mounting an overlayfs
+// with the shared extraction of the snapshot as lower layer at the
+// volume path. On success, vol.OverlayLowerDir is set and the caller
+// must store the volume.
+func (hp *hostPath) loadFromSnapshotOverlay(size int64, snapshotId string, vol *state.Volume) (finalErr error) {
+	snapshot, err := hp.getSnapshotForRestore(size, snapshotId)
+	if err != nil {
+		return err
+	}
+	lower := hp.getOverlayLowerPath(snapshot.Id)
+	if err := extractSnapshotOnce(snapshot, lower); err != nil {
+		return err
+	}
+	overlay := hp.getOverlayPath(vol.VolID)
+	defer func() {
+		if finalErr == nil {
+			return
+		}
+		if err := os.RemoveAll(overlay); err != nil {
+			klog.Errorf("failed to cleanup overlay directory %s: %v", overlay, err)
+		}
+		hp.releaseSnapshotExtraction(lower)
+	}()
+
+	upper := filepath.Join(overlay, "upper")
+	work := filepath.Join(overlay, "work")
+	for _, dir := range []string{upper, work} {
+		if err := os.MkdirAll(dir, 0750); err != nil {
+			return fmt.Errorf("failed to create overlay directory: %w", err)
+		}
+	}
+	// The root of the volume must be writable for everyone,
+	// like the directory of a normal volume.
+	if err := os.Chmod(upper, 0777); err != nil {
+		return fmt.Errorf("failed to change permissions of %s: %w", upper, err)
+	}
+
+	vol.OverlayLowerDir = lower
+	if err := hp.mountOverlay(newMounter(), *vol); err != nil {
+		vol.OverlayLowerDir = ""
+		return fmt.Errorf("failed to mount overlay for snapshot %v at %s: %w", snapshotId, vol.VolPath, err)
+	}
+	klog.V(4).Infof("mounted overlay for snapshot %s at %s", snapshotId, vol.VolPath)
+	return nil
+}
+
+// mountOverlay mounts the overlayfs of a clone at the volume path. The
+// upper and work directories must exist.
+func (hp *hostPath) mountOverlay(mounter mount.Interface, vol state.Volume) error {
+	overlay := hp.getOverlayPath(vol.VolID)
+	options := []string{
+		"lowerdir=" + vol.OverlayLowerDir,
+		"upperdir=" + filepath.Join(overlay, "upper"),
+		"workdir=" + filepath.Join(overlay, "work"),
+	}
+	return mounter.Mount("overlay", vol.VolPath, "overlay", options)
+}
+
+// unmountOverlay unmounts the overlayfs of a clone and removes its
+// upper and work directories.
+func (hp *hostPath) unmountOverlay(vol state.Volume) error {
+	if err := unmountVolumePath(vol); err != nil {
+		return err
+	}
+	overlay := hp.getOverlayPath(vol.VolID)
+	if err := os.RemoveAll(overlay); err != nil {
+		return fmt.Errorf("remove overlay directory %s: %w", overlay, err)
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hostpath
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/utils/mount"
+
+	"github.com/kubernetes-csi/csi-driver-host-path/pkg/state"
+)
+
+func TestCloneModeFromParameters(t *testing.T) {
+	cases := []struct {
+		testName string
+		params   map[string]string
+		result   string
+		success  bool
+	}{
+		{
+			testName: "no parameters",
+			result:   cloneModeCopy,
+			success:  true,
+		},
+		{
+			testName: "copy",
+			params:   map[string]string{cloneModeParameterName: "copy"},
+			result:   cloneModeCopy,
+			success:  true,
+		},
+		{
+			testName: "overlay",
+			params:   map[string]string{cloneModeParameterName: "overlay"},
+			result:   cloneModeOverlay,
+			success:  true,
+		},
+		{
+			testName: "invalid",
+			params:   map[string]string{cloneModeParameterName: "reflink"},
+			success:  false,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.testName, func(t *testing.T) {
+			result, err := cloneModeFromParameters(tc.params)
+			if tc.success {
+				require.NoError(t, err)
+				assert.Equal(t, tc.result, result)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestReleaseOverlayLower(t *testing.T) {
+	cfg := Config{
+		StateDir:   t.TempDir(),
+		Endpoint:   "unix://tmp/csi.sock",
+		DriverName: "hostpath.csi.k8s.io",
+		NodeID:     "fakeNodeID",
+	}
+	hp, err := NewHostPathDriver(cfg)
+	require.NoError(t, err)
+
+	lower := hp.getOverlayLowerPath("snap")
+	require.NoError(t, os.Mkdir(lower, 0750))
+	for _, volID := range []string{"clone-1", "clone-2"} {
+		require.NoError(t, hp.state.UpdateVolume(state.Volume{VolID: volID, VolName: volID, OverlayLowerDir: lower}))
+	}
+
+	require.NoError(t, hp.state.DeleteVolume("clone-1"))
+	hp.releaseSnapshotExtraction(lower)
+	assert.DirExists(t, lower, "lower layer still used by clone-2")
+
+	require.NoError(t, hp.state.DeleteVolume("clone-2"))
+	hp.releaseSnapshotExtraction(lower)
+	assert.NoDirExists(t, lower, "lower layer of last clone")
+}
+
+func TestRestoreOverlayMounts(t *testing.T) {
+	cfg := Config{
+		StateDir:   t.TempDir(),
+		Endpoint:   "unix://tmp/csi.sock",
+		DriverName: "hostpath.csi.k8s.io",
+		NodeID:     "fakeNodeID",
+	}
+	hp, err := NewHostPathDriver(cfg)
+	require.NoError(t, err)
+
+	lower := hp.getOverlayLowerPath("snap")
+	for _, dir := range []string{lower, hp.getVolumePath("clone"), hp.getVolumePath("plain")} {
+		require.NoError(t, os.Mkdir(dir, 0750))
+	}
+	require.NoError(t, hp.state.UpdateVolume(state.Volume{VolID: "clone", VolName: "clone", VolPath: hp.getVolumePath("clone"), OverlayLowerDir: lower}))
+	require.NoError(t, hp.state.UpdateVolume(state.Volume{VolID: "plain", VolName: "plain", VolPath: hp.getVolumePath("plain")}))
+
+	mounter := mount.NewFakeMounter(nil)
+	defer func(orig func() mount.Interface) { newMounter = orig }(newMounter)
+	newMounter = func() mount.Interface { return mounter }
+
+	// A restart of the driver starts with the same state but without
+	// the mounts.
+	hp, err = NewHostPathDriver(cfg)
+	require.NoError(t, err)
+	hp.restoreVolumeMounts()
+	overlay := hp.getOverlayPath("clone")
+	require.Equal(t, []mount.MountPoint{{
+		Device: "overlay",
+		Path:   hp.getVolumePath("clone"),
+		Type:   "overlay",
+		Opts: []string{
+			"lowerdir=" + lower,
+			"upperdir=" + filepath.Join(overlay, "upper"),
+			"workdir=" + filepath.Join(overlay, "work"),
+		},
+	}}, mounter.MountPoints)
+
+	// Mounts which are still present are not mounted again.
+	mounter.ResetLog()
+	hp.restoreVolumeMounts()
+	assert.Empty(t, mounter.GetLog())
+}
@@ -0,0 +1,172 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hostpath
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/sys/unix"
+	"k8s.io/klog/v2"
+	"k8s.io/kubernetes/pkg/volume/util/volumepathhandler"
+	utilexec "k8s.io/utils/exec"
+	"k8s.io/utils/mount"
+
+	"github.com/kubernetes-csi/csi-driver-host-path/pkg/state"
+)
+
+// quiescedVolume describes what was done to quiesce a volume and
+// therefore has to be undone when thawing it.
+type quiescedVolume struct {
+	vol state.Volume
+	// frozen is the mount point of the filesystem on the loop
+	// device of a block volume if it was frozen.
+	frozen string
+	// hooked is true if the pre hook was run successfully.
+	hooked bool
+}
+
+// quiesceVolumes stops writes to all volumes. Filesystems mounted from
+// the loop device of block volumes get frozen with fsfreeze. For all other
+// volumes, the configured pre hook gets invoked with the volume ID and
+// path as arguments.
+//
+// The returned thaw function must be called in all cases, also when
+// quiescing failed, to undo what was already done. active is true if
+// any volume was actually quiesced.
+func (hp *hostPath) quiesceVolumes(vols []state.Volume) (thaw func() error, active bool, finalErr error) {
+	var quiesced []quiescedVolume
+	thaw = func() error {
+		var errs []error
+		// Thaw in reverse order.
+		for i := len(quiesced) - 1; i >= 0; i-- {
+			if err := hp.thawVolume(quiesced[i]); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		quiesced = nil
+		return errors.Join(errs...)
+	}
+
+	var mounts []mount.MountInfo
+	for _, vol := range vols {
+		q := quiescedVolume{vol: vol}
+		switch vol.VolAccessType {
+		case state.BlockAccess:
+			if mounts == nil {
+				mis, err := mount.ParseMountInfo(mountInfoPath)
+				if err != nil {
+					return thaw, active, fmt.Errorf("list mount points: %w", err)
+				}
+				mounts = mis
+			}
+			mp, err := filesystemOnVolume(vol, mounts)
+			if err != nil {
+				return thaw, active, err
+			}
+			if mp != "" {
+				if err := runCommand("fsfreeze", "--freeze", mp); err != nil {
+					return thaw, active, fmt.Errorf("freeze volume %s: %w", vol.VolID, err)
+				}
+				q.frozen = mp
+				active = true
+			}
+		default:
+			if hp.config.QuiescePreHook != "" {
+				if err := runHook(hp.config.QuiescePreHook, vol); err != nil {
+					return thaw, active, fmt.Errorf("quiesce volume %s: %w", vol.VolID, err)
+				}
+				q.hooked = true
+				active = true
+			}
+		}
+		klog.V(4).Infof("quiesced volume %s: %+v", vol.VolID, q)
+		quiesced = append(quiesced, q)
+	}
+	return thaw, active, nil
+}
+
+// thawVolume undoes what quiesceVolumes did for the volume.
+func (hp *hostPath) thawVolume(q quiescedVolume) error {
+	var errs []error
+	if q.frozen != "" {
+		if err := runCommand("fsfreeze", "--unfreeze", q.frozen); err != nil {
+			errs = append(errs, fmt.Errorf("thaw volume %s: %w", q.vol.VolID, err))
+		}
+	}
+	if q.hooked && hp.config.QuiescePostHook != "" {
+		if err := runHook(hp.config.QuiescePostHook, q.vol); err != nil {
+			errs = append(errs, fmt.Errorf("thaw volume %s: %w", q.vol.VolID, err))
+		}
+	}
+	klog.V(4).Infof("thawed volume %s", q.vol.VolID)
+	return errors.Join(errs...)
+}
+
+// filesystemOnVolume returns a mount point of the filesystem which is
+// mounted from the loop device of a block volume, or an empty string if
+// there is none.
+func filesystemOnVolume(vol state.Volume, mounts []mount.MountInfo) (string, error) {
+	volPathHandler := volumepathhandler.VolumePathHandler{}
+	loopDevice, err := volPathHandler.GetLoopDevice(vol.VolPath)
+	if err != nil {
+		if err.Error() == volumepathhandler.ErrDeviceNotFound {
+			return "", nil
+		}
+		return "", fmt.Errorf("get loop device of volume %s: %w", vol.VolID, err)
+	}
+	var st unix.Stat_t
+	if err := unix.Stat(loopDevice, &st); err != nil {
+		return "", fmt.Errorf("stat loop device %s of volume %s: %w", loopDevice, vol.VolID, err)
+	}
+	return mountPointOfDevice(unix.Major(uint64(st.Rdev)), unix.Minor(uint64(st.Rdev)), mounts), nil
+}
+
+// mountPointOfDevice returns one mount point of the filesystem on the
+// block device. The kernel refuses to freeze a filesystem twice, so
+// bind mounts of it must be skipped. All of them share the device
+// number of the superblock. Block volumes get published with bind
+// mounts of the device node, which belong to devtmpfs and therefore
+// do not match.
+func mountPointOfDevice(major, minor uint32, mounts []mount.MountInfo) string {
+	for _, mi := range mounts {
+		if mi.Major == int(major) && mi.Minor == int(minor) {
+			return mi.MountPoint
+		}
+	}
+	return ""
+}
+
+// runHook invokes the hook command with the volume ID and path as
+// additional arguments.
+func runHook(hook string, vol state.Volume) error {
+	args := strings.Fields(hook)
+	args = append(args, vol.VolID, vol.VolPath)
+	return runCommand(args[0], args[1:]...)
+}
+
+func runCommand(cmd string, args ...string) error {
+	executor := utilexec.New()
+	klog.V(4).Infof("Command Start: %s %v", cmd, args)
+	out, err := executor.Command(cmd, args...).CombinedOutput()
+	klog.V(4).Infof("Command Finish: %v", string(out))
+	if err != nil {
+		return fmt.Errorf("%s failed: %w: %s", cmd, err, out)
+	}
+	return nil
+}
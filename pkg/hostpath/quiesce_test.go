@@ -0,0 +1,188 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hostpath
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/utils/mount"
+
+	"github.com/kubernetes-csi/csi-driver-host-path/pkg/state"
+)
+
+// writeHook creates a shell script which appends its name and arguments
+// to the log file and fails for the given volume ID.
+func writeHook(t *testing.T, dir, name, logFile, failFor string) string {
+	script := fmt.Sprintf(`#!/bin/sh
+echo "%s $1" >>%s
+[ "$1" != "%s" ]
+`, name, logFile, failFor)
+	path := filepath.Join(dir, name)
+	require.NoError(t, os.WriteFile(path, []byte(script), 0755))
+	return path
+}
+
+func TestCreateVolumeGroupSnapshotQuiesce(t *testing.T) {
+	testCases := []struct {
+		name         string
+		failPreFor   string
+		expectErr    bool
+		expectedLogs []string
+	}{
+		{
+			name: "success case",
+			// Checksums are computed after thawing.
+			expectedLogs: []string{
+				"pre vol-1",
+				"pre vol-2",
+				"post vol-2",
+				"post vol-1",
+				"checksum",
+				"checksum",
+			},
+		},
+		{
+			name:       "failure case - pre hook fails",
+			failPreFor: "vol-2",
+			expectErr:  true,
+			expectedLogs: []string{
+				"pre vol-1",
+				"pre vol-2",
+				"post vol-1",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			hookDir := t.TempDir()
+			logFile := filepath.Join(hookDir, "log")
+			cfg := Config{
+				StateDir:        t.TempDir(),
+				Endpoint:        "unix://tmp/csi.sock",
+				DriverName:      "hostpath.csi.k8s.io",
+				NodeID:          "fakeNodeID",
+				MaxVolumeSize:   1024 * 1024 * 1024 * 1024,
+				QuiescePreHook:  writeHook(t, hookDir, "pre", logFile, tc.failPreFor),
+				QuiescePostHook: writeHook(t, hookDir, "post", logFile, ""),
+			}
+			hp, err := NewHostPathDriver(cfg)
+			require.NoError(t, err)
+			defer func(orig func(string) (string, error)) { memberChecksum = orig }(memberChecksum)
+			memberChecksum = func(path string) (string, error) {
+				assert.Empty(t, hp.state.GetSnapshots(), "snapshots must be stored after computing checksums")
+				// Runs in a worker goroutine, so it must not use require.
+				f, err := os.OpenFile(logFile, os.O_APPEND|os.O_WRONLY, 0)
+				if !assert.NoError(t, err) {
+					return "", err
+				}
+				defer f.Close()
+				_, err = f.WriteString("checksum\n")
+				assert.NoError(t, err)
+				return fileChecksum(path)
+			}
+			volumes := []string{"vol-1", "vol-2"}
+			for _, volID := range volumes {
+				_, err := hp.createVolume(volID, volID+"-name", 1024, state.MountAccess, false, "")
+				require.NoError(t, err)
+			}
+
+			_, err = hp.CreateVolumeGroupSnapshot(context.TODO(), &csi.CreateVolumeGroupSnapshotRequest{
+				Name:            "group",
+				SourceVolumeIds: volumes,
+			})
+			logs, readErr := os.ReadFile(logFile)
+			require.NoError(t, readErr)
+			assert.Equal(t, tc.expectedLogs, strings.Split(strings.TrimSpace(string(logs)), "\n"))
+			if tc.expectErr {
+				require.Error(t, err)
+				assert.Empty(t, hp.state.GetGroupSnapshots())
+				return
+			}
+			require.NoError(t, err)
+			groupSnapshots := hp.state.GetGroupSnapshots()
+			require.Len(t, groupSnapshots, 1)
+			freezeStart := groupSnapshots[0].FreezeStartTime.AsTime()
+			freezeEnd := groupSnapshots[0].FreezeEndTime.AsTime()
+			assert.False(t, freezeEnd.Before(freezeStart), "freeze end before start")
+		})
+	}
+}
+
+func TestCreateVolumeGroupSnapshotWithoutQuiesce(t *testing.T) {
+	cfg := Config{
+		StateDir:      t.TempDir(),
+		Endpoint:      "unix://tmp/csi.sock",
+		DriverName:    "hostpath.csi.k8s.io",
+		NodeID:        "fakeNodeID",
+		MaxVolumeSize: 1024 * 1024 * 1024 * 1024,
+	}
+	hp, err := NewHostPathDriver(cfg)
+	require.NoError(t, err)
+	_, err = hp.createVolume("vol-1", "vol-1-name", 1024, state.MountAccess, false, "")
+	require.NoError(t, err)
+
+	_, err = hp.CreateVolumeGroupSnapshot(context.TODO(), &csi.CreateVolumeGroupSnapshotRequest{
+		Name:            "group",
+		SourceVolumeIds: []string{"vol-1"},
+	})
+	require.NoError(t, err)
+	groupSnapshots := hp.state.GetGroupSnapshots()
+	require.Len(t, groupSnapshots, 1)
+	assert.Nil(t, groupSnapshots[0].FreezeStartTime, "nothing was quiesced")
+	assert.Nil(t, groupSnapshots[0].FreezeEndTime, "nothing was quiesced")
+}
+
+func TestMountPointOfDevice(t *testing.T) {
+	mounts := []mount.MountInfo{
+		{Major: 0, Minor: 5, FsType: "devtmpfs", Source: "devtmpfs", MountPoint: "/var/lib/kubelet/plugins/kubernetes.io/csi/volumeDevices/publish/vol"},
+		{Major: 7, Minor: 0, FsType: "ext4", Source: "/dev/loop0", MountPoint: "/mnt/data"},
+		{Major: 7, Minor: 0, FsType: "ext4", Source: "/dev/loop0", MountPoint: "/var/lib/pod/data"},
+		{Major: 7, Minor: 1, FsType: "xfs", Source: "/dev/loop1", MountPoint: "/mnt/other"},
+	}
+	testCases := []struct {
+		name         string
+		major, minor uint32
+		expected     string
+	}{
+		{
+			name:     "bind mounts are skipped",
+			major:    7,
+			minor:    0,
+			expected: "/mnt/data",
+		},
+		{
+			name:  "not mounted",
+			major: 7,
+			minor: 2,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, mountPointOfDevice(tc.major, tc.minor, mounts))
+		})
+	}
+}
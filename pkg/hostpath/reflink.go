@@ -0,0 +1,140 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hostpath
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+	"k8s.io/klog/v2"
+)
+
+// Methods for copying data, from cheapest to most expensive.
+const (
+	// copyMethodReflink shares the data extents between source and
+	// destination (FICLONE). Only supported by some filesystems,
+	// for example XFS and btrfs.
+	copyMethodReflink = "reflink"
+	// copyMethodCopyFileRange copies inside the kernel without
+	// transferring data through user space.
+	copyMethodCopyFileRange = "copy_file_range"
+	// copyMethodFull is the traditional copy with cp, dd or tar.
+	copyMethodFull = "full"
+)
+
+// Operations which copy data, used as metrics label.
+const (
+	copyOperationCloneVolume      = "clone_volume"
+	copyOperationCreateSnapshot   = "create_snapshot"
+	copyOperationPopulateTemplate = "populate_template"
+)
+
+// copyFileRangeChunkSize limits how much data a single copy_file_range
+// call copies, so that cancellation is noticed in between.
+const copyFileRangeChunkSize = 64 * 1024 * 1024
+
+// errFastCopyUnsupported is returned by fastCopyFile when neither
+// reflinks nor copy_file_range can be used.
+var errFastCopyUnsupported = errors.New("fast copy not supported")
+
+// recordCopyMethod logs and counts how data was copied.
+func recordCopyMethod(operation, method, src, dst string) {
+	klog.V(4).Infof("%s: copied %s to %s using %s", operation, src, dst, method)
+	copyOperations.WithLabelValues(operation, method).Inc()
+}
+
+// fastCopyFile copies the content of src into dst, which must exist,
+// by cloning the data extents or, if that is not possible, by copying
+// them inside the kernel. The destination keeps its size if it is
+// larger than the source, which matters for files which are attached
+// to a loop device.
+//
+// It returns the method that was used or errFastCopyUnsupported if the
+// caller has to fall back to copying the data itself. Copying stops with
+// the error of the context when that gets canceled.
+func fastCopyFile(ctx context.Context, src, dst string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	srcFile, err := os.Open(src)
+	if err != nil {
+		return "", err
+	}
+	defer srcFile.Close()
+	srcInfo, err := srcFile.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	dstFile, err := os.OpenFile(dst, os.O_WRONLY, 0)
+	if err != nil {
+		return "", err
+	}
+	defer dstFile.Close()
+	dstInfo, err := dstFile.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	err = unix.IoctlFileClone(int(dstFile.Fd()), int(srcFile.Fd()))
+	if err == nil {
+		// Cloning replaces the whole file, including its size.
+		if dstInfo.Size() > srcInfo.Size() {
+			if err := dstFile.Truncate(dstInfo.Size()); err != nil {
+				return "", fmt.Errorf("restore size of %s: %w", dst, err)
+			}
+		}
+		return copyMethodReflink, nil
+	}
+	klog.V(5).Infof("cannot clone %s to %s: %v", src, dst, err)
+
+	var copied int64
+	for copied < srcInfo.Size() {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+		n, err := unix.CopyFileRange(int(srcFile.Fd()), nil, int(dstFile.Fd()), nil, int(min(srcInfo.Size()-copied, copyFileRangeChunkSize)), 0)
+		if err != nil {
+			if copied == 0 && copyFileRangeUnsupported(err) {
+				klog.V(5).Infof("cannot copy %s to %s with copy_file_range: %v", src, dst, err)
+				return "", errFastCopyUnsupported
+			}
+			return "", fmt.Errorf("copy_file_range %s to %s: %w", src, dst, err)
+		}
+		if n == 0 {
+			// Source got truncated while copying?!
+			return "", fmt.Errorf("copy_file_range %s to %s: unexpected end of file after %d bytes", src, dst, copied)
+		}
+		copied += int64(n)
+	}
+	return copyMethodCopyFileRange, nil
+}
+
+// copyFileRangeUnsupported returns true if copy_file_range failed because
+// it cannot be used for the files, for example because they are on
+// different filesystems or the kernel is too old. Other errors, like
+// I/O errors or a full disk, would also happen when copying the data
+// in some other way.
+func copyFileRangeUnsupported(err error) bool {
+	return errors.Is(err, unix.EXDEV) ||
+		errors.Is(err, unix.EOPNOTSUPP) ||
+		errors.Is(err, unix.ENOSYS) ||
+		errors.Is(err, unix.EINVAL)
+}
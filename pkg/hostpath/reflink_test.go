@@ -0,0 +1,132 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hostpath
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+
+	"github.com/kubernetes-csi/csi-driver-host-path/pkg/state"
+)
+
+func TestFastCopyFile(t *testing.T) {
+	testCases := []struct {
+		name    string
+		srcSize int
+		dstSize int
+	}{
+		{
+			name:    "same size",
+			srcSize: 4 * 4096,
+			dstSize: 4 * 4096,
+		},
+		{
+			name:    "larger destination keeps its size",
+			srcSize: 4 * 4096,
+			dstSize: 16 * 4096,
+		},
+		{
+			name:    "empty source",
+			dstSize: 4096,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			src := filepath.Join(dir, "src")
+			dst := filepath.Join(dir, "dst")
+			content := bytes.Repeat([]byte{0xab}, tc.srcSize)
+			require.NoError(t, os.WriteFile(src, content, 0600))
+			require.NoError(t, os.WriteFile(dst, make([]byte, tc.dstSize), 0600))
+
+			method, err := fastCopyFile(context.Background(), src, dst)
+			if errors.Is(err, errFastCopyUnsupported) {
+				t.Skipf("neither reflinks nor copy_file_range supported in %s", dir)
+			}
+			require.NoError(t, err)
+			assert.Contains(t, []string{copyMethodReflink, copyMethodCopyFileRange}, method)
+
+			data, err := os.ReadFile(dst)
+			require.NoError(t, err)
+			assert.Len(t, data, max(tc.srcSize, tc.dstSize), "destination size")
+			assert.Equal(t, content, data[:tc.srcSize], "copied content")
+			assert.Equal(t, make([]byte, len(data)-tc.srcSize), data[tc.srcSize:], "remaining content")
+		})
+	}
+}
+
+func TestCreateSnapshotFromBlockVolumeRetry(t *testing.T) {
+	dir := t.TempDir()
+	volPath := filepath.Join(dir, "vol")
+	file := filepath.Join(dir, "snap")
+	content := bytes.Repeat([]byte{0xab}, 4*4096)
+	require.NoError(t, os.WriteFile(volPath, content, 0600))
+	// Left behind by an earlier attempt which failed halfway.
+	require.NoError(t, os.WriteFile(file, bytes.Repeat([]byte{0xcd}, 16*4096), 0600))
+
+	_, err := createSnapshotFromBlockVolume(context.Background(), state.Volume{VolID: "vol", VolPath: volPath}, file)
+	if errors.Is(err, errFastCopyUnsupported) {
+		t.Skipf("neither reflinks nor copy_file_range supported in %s", dir)
+	}
+	require.NoError(t, err)
+	data, err := os.ReadFile(file)
+	require.NoError(t, err)
+	assert.Equal(t, content, data, "stale snapshot file must be overwritten")
+}
+
+func TestCreateSnapshotFromBlockVolumeCanceled(t *testing.T) {
+	dir := t.TempDir()
+	volPath := filepath.Join(dir, "vol")
+	file := filepath.Join(dir, "snap")
+	require.NoError(t, os.WriteFile(volPath, bytes.Repeat([]byte{0xab}, 4*4096), 0600))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := createSnapshotFromBlockVolume(ctx, state.Volume{VolID: "vol", VolPath: volPath}, file)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.NoFileExists(t, file, "snapshot file of canceled copy")
+}
+
+func TestCopyFileRangeUnsupported(t *testing.T) {
+	testCases := []struct {
+		err         error
+		unsupported bool
+	}{
+		{err: unix.EXDEV, unsupported: true},
+		{err: unix.EOPNOTSUPP, unsupported: true},
+		{err: unix.ENOSYS, unsupported: true},
+		{err: unix.EINVAL, unsupported: true},
+		{err: unix.EIO},
+		{err: unix.ENOSPC},
+		{err: unix.EBADF},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.err.Error(), func(t *testing.T) {
+			assert.Equal(t, tc.unsupported, copyFileRangeUnsupported(tc.err))
+		})
+	}
+}
@@ -0,0 +1,85 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hostpath
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/mount"
+
+	"github.com/kubernetes-csi/csi-driver-host-path/pkg/state"
+)
+
+// Extension of the directories with snapshots that are shared by
+// read-only volumes.
+const sharedSnapshotExt = ".shared"
+
+// isReadOnlyRequest returns true if all volume capabilities only
+// allow reading.
+func isReadOnlyRequest(caps []*csi.VolumeCapability) bool {
+	if len(caps) == 0 {
+		return false
+	}
+	for _, cap := range caps {
+		switch cap.GetAccessMode().GetMode() {
+		case csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY,
+			csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// getSharedSnapshotPath returns the path of the extraction of a snapshot
+// which is shared by all read-only volumes restored from it.
+func (hp *hostPath) getSharedSnapshotPath(snapshotID string) string {
+	return filepath.Join(hp.config.StateDir, snapshotID+sharedSnapshotExt)
+}
+
+// loadFromSnapshotShared populates a read-only volume by bind-mounting the
+// shared extraction of the snapshot read-only at the volume path. On
+// success, vol.SharedSnapshotDir is set and the caller must store the
+// volume.
+func (hp *hostPath) loadFromSnapshotShared(size int64, snapshotId string, vol *state.Volume) error {
+	snapshot, err := hp.getSnapshotForRestore(size, snapshotId)
+	if err != nil {
+		return err
+	}
+	shared := hp.getSharedSnapshotPath(snapshot.Id)
+	if err := extractSnapshotOnce(snapshot, shared); err != nil {
+		return err
+	}
+
+	vol.SharedSnapshotDir = shared
+	if err := mountSharedSnapshot(newMounter(), *vol); err != nil {
+		vol.SharedSnapshotDir = ""
+		hp.releaseSnapshotExtraction(shared)
+		return fmt.Errorf("failed to bind-mount snapshot %v at %s: %w", snapshotId, vol.VolPath, err)
+	}
+	klog.V(4).Infof("bind-mounted shared snapshot %s read-only at %s", snapshotId, vol.VolPath)
+	return nil
+}
+
+// mountSharedSnapshot bind-mounts the shared extraction of a snapshot
+// read-only at the volume path.
+func mountSharedSnapshot(mounter mount.Interface, vol state.Volume) error {
+	return mounter.Mount(vol.SharedSnapshotDir, vol.VolPath, "", []string{"bind", "ro"})
+}
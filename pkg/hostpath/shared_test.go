@@ -0,0 +1,142 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hostpath
+
+import (
+	"os"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/utils/mount"
+
+	"github.com/kubernetes-csi/csi-driver-host-path/pkg/state"
+)
+
+func TestIsReadOnlyRequest(t *testing.T) {
+	capability := func(mode csi.VolumeCapability_AccessMode_Mode) *csi.VolumeCapability {
+		return &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{
+				Mount: &csi.VolumeCapability_MountVolume{},
+			},
+			AccessMode: &csi.VolumeCapability_AccessMode{
+				Mode: mode,
+			},
+		}
+	}
+
+	testCases := []struct {
+		name     string
+		caps     []*csi.VolumeCapability
+		readOnly bool
+	}{
+		{
+			name: "no capabilities",
+		},
+		{
+			name:     "multi node reader only",
+			caps:     []*csi.VolumeCapability{capability(csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY)},
+			readOnly: true,
+		},
+		{
+			name: "all reader only",
+			caps: []*csi.VolumeCapability{
+				capability(csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY),
+				capability(csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY),
+			},
+			readOnly: true,
+		},
+		{
+			name: "reader and writer",
+			caps: []*csi.VolumeCapability{
+				capability(csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY),
+				capability(csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER),
+			},
+		},
+		{
+			name: "no access mode",
+			caps: []*csi.VolumeCapability{{}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.readOnly, isReadOnlyRequest(tc.caps))
+		})
+	}
+}
+
+func TestSharedSnapshotRefs(t *testing.T) {
+	cfg := Config{
+		StateDir:   t.TempDir(),
+		Endpoint:   "unix://tmp/csi.sock",
+		DriverName: "hostpath.csi.k8s.io",
+		NodeID:     "fakeNodeID",
+	}
+	hp, err := NewHostPathDriver(cfg)
+	require.NoError(t, err)
+
+	shared := hp.getSharedSnapshotPath("snap")
+	require.NoError(t, os.Mkdir(shared, 0750))
+	for _, volID := range []string{"reader-1", "reader-2"} {
+		require.NoError(t, hp.state.UpdateVolume(state.Volume{VolID: volID, VolName: volID, SharedSnapshotDir: shared}))
+	}
+	assert.Equal(t, 2, hp.snapshotExtractionRefs(shared))
+
+	require.NoError(t, hp.state.DeleteVolume("reader-1"))
+	hp.releaseSnapshotExtraction(shared)
+	assert.DirExists(t, shared, "shared snapshot still used by reader-2")
+
+	require.NoError(t, hp.state.DeleteVolume("reader-2"))
+	hp.releaseSnapshotExtraction(shared)
+	assert.NoDirExists(t, shared, "shared snapshot of last reader")
+}
+
+func TestRestoreSharedSnapshotMounts(t *testing.T) {
+	cfg := Config{
+		StateDir:   t.TempDir(),
+		Endpoint:   "unix://tmp/csi.sock",
+		DriverName: "hostpath.csi.k8s.io",
+		NodeID:     "fakeNodeID",
+	}
+	hp, err := NewHostPathDriver(cfg)
+	require.NoError(t, err)
+
+	shared := hp.getSharedSnapshotPath("snap")
+	require.NoError(t, os.Mkdir(shared, 0750))
+	for _, volID := range []string{"reader-1", "reader-2"} {
+		require.NoError(t, os.Mkdir(hp.getVolumePath(volID), 0750))
+		require.NoError(t, hp.state.UpdateVolume(state.Volume{VolID: volID, VolName: volID, VolPath: hp.getVolumePath(volID), SharedSnapshotDir: shared}))
+	}
+
+	// reader-1 survived the restart of the driver, reader-2 did not.
+	mounter := mount.NewFakeMounter([]mount.MountPoint{{Device: shared, Path: hp.getVolumePath("reader-1")}})
+	defer func(orig func() mount.Interface) { newMounter = orig }(newMounter)
+	newMounter = func() mount.Interface { return mounter }
+
+	hp, err = NewHostPathDriver(cfg)
+	require.NoError(t, err)
+	hp.restoreVolumeMounts()
+	assert.Equal(t, []mount.FakeAction{{
+		Action: mount.FakeActionMount,
+		Target: hp.getVolumePath("reader-2"),
+		Source: shared,
+	}}, mounter.GetLog())
+	require.Len(t, mounter.MountPoints, 2)
+	assert.Equal(t, []string{"bind", "ro"}, mounter.MountPoints[1].Opts)
+}
@@ -18,11 +18,13 @@ package hostpath
 
 import (
 	"bytes"
+	"errors"
 	"io"
 	"os"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"golang.org/x/net/context"
+	"golang.org/x/sys/unix"
 	"k8s.io/klog/v2"
 )
 
@@ -36,6 +38,31 @@ type fileBlockReader struct {
 	blockSize         int64
 	blockMetadataType csi.BlockMetadataType
 	maxResult         int32
+	// maxExtentLength limits the length of merged extents in
+	// VARIABLE_LENGTH mode, zero means no limit.
+	maxExtentLength int64
+	// workers is the number of segments which get compared
+	// concurrently. Values below two select the sequential
+	// comparison.
+	workers int
+	// segmentBytes is the amount of data compared by one worker at a
+	// time, defaultSegmentBytes when zero.
+	segmentBytes int64
+	// The state of the parallel comparison: the sources of both
+	// files, per-worker buffers, changed blocks which did not fit into
+	// the last response and the offset of the next segment.
+	baseSource    *blockSource
+	targetSource  *blockSource
+	segmentBlocks int64
+	buffers       [][2][]byte
+	zeroBlock     []byte
+	pending       []int64
+	pendingEOF    bool
+	nextOffset    int64
+	// zeroScan is set when the filesystem cannot report the
+	// allocated extents of the target file. Allocated blocks are
+	// then found by comparing each block against zeros.
+	zeroScan bool
 }
 
 func newFileBlockReader(
@@ -75,6 +102,12 @@ func (cb *fileBlockReader) seekToStartingOffset() error {
 }
 
 func (cb *fileBlockReader) Close() error {
+	if err := cb.baseSource.Close(); err != nil {
+		return err
+	}
+	if err := cb.targetSource.Close(); err != nil {
+		return err
+	}
 	if cb.base != nil {
 		if err := cb.base.Close(); err != nil {
 			return err
@@ -109,6 +142,20 @@ func openFiles(basePath, targetPath string) (base, target *os.File, err error) {
 // and returns list of changed block metadata. It reads all the blocks till it reaches EOF or size of changed block
 // metadata list <= maxSize.
 func (cb *fileBlockReader) getChangedBlockMetadata(ctx context.Context) ([]*csi.BlockMetadata, error) {
+	if cb.base == nil && !cb.zeroScan {
+		changedBlocks, err := cb.getAllocatedBlockMetadata(ctx)
+		if !errors.Is(err, errSeekDataUnsupported) {
+			return changedBlocks, err
+		}
+		klog.V(4).Infof("SEEK_DATA not supported for %s, falling back to comparing blocks against zeros", cb.target.Name())
+		cb.zeroScan = true
+		if err := cb.seekToStartingOffset(); err != nil {
+			return nil, err
+		}
+	}
+	if cb.workers > 1 {
+		return cb.getChangedBlockMetadataParallel(ctx)
+	}
 	if cb.base == nil {
 		klog.V(4).Infof("finding allocated blocks by file: %s", cb.target.Name())
 	} else {
@@ -148,7 +195,7 @@ func (cb *fileBlockReader) getChangedBlockMetadata(ctx context.Context) ([]*csi.
 			if blockChanged(zeroBlock, tBuffer[:targetReadBytes]) {
 				// if VARIABLE_LENGTH type is enabled, return blocks extend instead of individual blocks.
 				blockMetadata := createBlockMetadata(blockIndex, cb.blockSize)
-				if extendBlock(changedBlocks, csi.BlockMetadataType(cb.blockMetadataType), blockIndex, cb.blockSize) {
+				if extendBlock(changedBlocks, cb.blockMetadataType, blockIndex, cb.blockSize, cb.maxExtentLength) {
 					changedBlocks[len(changedBlocks)-1].SizeBytes += cb.blockSize
 					cb.offset += cb.blockSize
 					blockIndex++
@@ -180,7 +227,7 @@ func (cb *fileBlockReader) getChangedBlockMetadata(ctx context.Context) ([]*csi.
 			blockMetadata := createBlockMetadata(blockIndex, cb.blockSize)
 			// if VARIABLE_LEGTH type is enabled, check if blocks are adjacent,
 			// extend the previous block if adjacent blocks found instead of adding new entry.
-			if extendBlock(changedBlocks, csi.BlockMetadataType(cb.blockMetadataType), blockIndex, cb.blockSize) {
+			if extendBlock(changedBlocks, cb.blockMetadataType, blockIndex, cb.blockSize, cb.maxExtentLength) {
 				changedBlocks[len(changedBlocks)-1].SizeBytes += cb.blockSize
 				cb.offset += cb.blockSize
 				blockIndex++
@@ -220,7 +267,7 @@ func createBlockMetadata(blockIndex, blockSize int64) *csi.BlockMetadata {
 	}
 }
 
-func extendBlock(changedBlocks []*csi.BlockMetadata, metadataType csi.BlockMetadataType, blockIndex, blockSize int64) bool {
+func extendBlock(changedBlocks []*csi.BlockMetadata, metadataType csi.BlockMetadataType, blockIndex, blockSize, maxExtentLength int64) bool {
 	blockMetadata := createBlockMetadata(blockIndex, blockSize)
 	// if VARIABLE_LEGTH type is enabled, check if blocks are adjacent,
 	// extend the previous block if adjacent blocks found instead of adding new entry.
@@ -228,9 +275,69 @@ func extendBlock(changedBlocks []*csi.BlockMetadata, metadataType csi.BlockMetad
 		return false
 	}
 	lastBlock := changedBlocks[len(changedBlocks)-1]
+	// Don't extend beyond the maximum extent length, if there is one.
+	if maxExtentLength > 0 && lastBlock.SizeBytes+blockSize > maxExtentLength {
+		return false
+	}
 	if blockMetadata.ByteOffset == lastBlock.ByteOffset+lastBlock.SizeBytes &&
 		metadataType == csi.BlockMetadataType_VARIABLE_LENGTH {
 		return true
 	}
 	return false
 }
+
+// errSeekDataUnsupported is returned by getAllocatedBlockMetadata when the
+// filesystem does not support SEEK_DATA and SEEK_HOLE.
+var errSeekDataUnsupported = errors.New("SEEK_DATA not supported")
+
+// getAllocatedBlockMetadata returns the blocks of the target file which
+// overlap with its allocated extents, as reported by SEEK_DATA and
+// SEEK_HOLE. Unlike comparing blocks against zeros, this takes time
+// proportional to the number of extents and also reports blocks to which
+// zeros were written. Like getChangedBlockMetadata, it returns io.EOF
+// together with the last blocks.
+func (cb *fileBlockReader) getAllocatedBlockMetadata(ctx context.Context) ([]*csi.BlockMetadata, error) {
+	klog.V(4).Infof("finding allocated extents of file: %s", cb.target.Name())
+	fd := int(cb.target.Fd())
+	changedBlocks := []*csi.BlockMetadata{}
+
+	for {
+		select {
+		case <-ctx.Done():
+			klog.V(4).Infof("handling cancellation signal, closing goroutine")
+			return nil, ctx.Err()
+		default:
+		}
+
+		blockIndex := cb.offset / cb.blockSize
+		dataStart, err := unix.Seek(fd, blockIndex*cb.blockSize, unix.SEEK_DATA)
+		switch {
+		case errors.Is(err, unix.ENXIO):
+			// No more data after the offset.
+			return changedBlocks, io.EOF
+		case errors.Is(err, unix.EINVAL), errors.Is(err, unix.EOPNOTSUPP):
+			return nil, errSeekDataUnsupported
+		case err != nil:
+			return nil, err
+		}
+		dataEnd, err := unix.Seek(fd, dataStart, unix.SEEK_HOLE)
+		if err != nil {
+			return nil, err
+		}
+
+		// Report all blocks which overlap with the extent.
+		endIndex := (dataEnd + cb.blockSize - 1) / cb.blockSize
+		for blockIndex = max(blockIndex, dataStart/cb.blockSize); blockIndex < endIndex; blockIndex++ {
+			if int32(len(changedBlocks)) >= cb.maxResult {
+				cb.offset = blockIndex * cb.blockSize
+				return changedBlocks, nil
+			}
+			if extendBlock(changedBlocks, cb.blockMetadataType, blockIndex, cb.blockSize, cb.maxExtentLength) {
+				changedBlocks[len(changedBlocks)-1].SizeBytes += cb.blockSize
+				continue
+			}
+			changedBlocks = append(changedBlocks, createBlockMetadata(blockIndex, cb.blockSize))
+		}
+		cb.offset = endIndex * cb.blockSize
+	}
+}
@@ -18,9 +18,11 @@ package hostpath
 
 import (
 	"context"
+	"fmt"
 	"io"
 	"math"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
@@ -34,6 +36,8 @@ func TestGetChangedBlockMetadata(t *testing.T) {
 		targetFileBlocks  int
 		changedBlocks     []int
 		blockMetadataType csi.BlockMetadataType
+		blockSize         int64
+		maxExtentLength   int64
 		startingOffset    int64
 		maxResult         int32
 		expectedResponse  []*csi.BlockMetadata
@@ -228,6 +232,59 @@ func TestGetChangedBlockMetadata(t *testing.T) {
 				},
 			},
 		},
+		{
+			name:             "success case with larger block size",
+			sourceFileBlocks: 100,
+			targetFileBlocks: 100,
+			changedBlocks:    []int{2, 3, 7, 30},
+			blockSize:        4 * state.BlockSizeBytes,
+			maxResult:        100,
+			expectedResponse: []*csi.BlockMetadata{
+				{
+					ByteOffset: 0,
+					SizeBytes:  4 * state.BlockSizeBytes,
+				},
+				{
+					ByteOffset: 4 * state.BlockSizeBytes,
+					SizeBytes:  4 * state.BlockSizeBytes,
+				},
+				{
+					ByteOffset: 28 * state.BlockSizeBytes,
+					SizeBytes:  4 * state.BlockSizeBytes,
+				},
+			},
+		},
+		{
+			name:              "success case with variable length and maximum extent length",
+			sourceFileBlocks:  100,
+			targetFileBlocks:  100,
+			changedBlocks:     []int{3, 4, 5, 6, 7, 47, 48, 49},
+			blockMetadataType: csi.BlockMetadataType_VARIABLE_LENGTH,
+			maxExtentLength:   2 * state.BlockSizeBytes,
+			maxResult:         100,
+			expectedResponse: []*csi.BlockMetadata{
+				{
+					ByteOffset: 3 * state.BlockSizeBytes,
+					SizeBytes:  state.BlockSizeBytes * 2,
+				},
+				{
+					ByteOffset: 5 * state.BlockSizeBytes,
+					SizeBytes:  state.BlockSizeBytes * 2,
+				},
+				{
+					ByteOffset: 7 * state.BlockSizeBytes,
+					SizeBytes:  state.BlockSizeBytes,
+				},
+				{
+					ByteOffset: 47 * state.BlockSizeBytes,
+					SizeBytes:  state.BlockSizeBytes * 2,
+				},
+				{
+					ByteOffset: 49 * state.BlockSizeBytes,
+					SizeBytes:  state.BlockSizeBytes,
+				},
+			},
+		},
 		{
 			name:              "success case with starting offset and variable length",
 			sourceFileBlocks:  100,
@@ -274,10 +331,15 @@ func TestGetChangedBlockMetadata(t *testing.T) {
 				modifyBlock(t, targetFile, i, []byte("changed block"))
 			}
 
-			br, err1 := newFileBlockReader(sourceFile.Name(), targetFile.Name(), tc.startingOffset, state.BlockSizeBytes, tc.blockMetadataType, tc.maxResult)
+			blockSize := tc.blockSize
+			if blockSize == 0 {
+				blockSize = state.BlockSizeBytes
+			}
+			br, err1 := newFileBlockReader(sourceFile.Name(), targetFile.Name(), tc.startingOffset, blockSize, tc.blockMetadataType, tc.maxResult)
 			if err1 != nil {
 				t.Fatalf("expected no error, got: %v", err1)
 			}
+			br.maxExtentLength = tc.maxExtentLength
 			if err := br.seekToStartingOffset(); err != nil {
 				t.Fatalf("expected no error, got: %v", err1)
 			}
@@ -465,3 +527,319 @@ func TestGetAllocatedBlockMetadata(t *testing.T) {
 		})
 	}
 }
+
+func TestGetAllocatedBlockMetadataSparse(t *testing.T) {
+	testCases := []struct {
+		name              string
+		zeroScan          bool
+		blockMetadataType csi.BlockMetadataType
+		expectedResponse  []*csi.BlockMetadata
+	}{
+		{
+			name: "extents",
+			expectedResponse: []*csi.BlockMetadata{
+				createBlockMetadata(3, state.BlockSizeBytes),
+				createBlockMetadata(4, state.BlockSizeBytes),
+				createBlockMetadata(50, state.BlockSizeBytes),
+				createBlockMetadata(70, state.BlockSizeBytes),
+			},
+		},
+		{
+			name:              "extents with variable length",
+			blockMetadataType: csi.BlockMetadataType_VARIABLE_LENGTH,
+			expectedResponse: []*csi.BlockMetadata{
+				{
+					ByteOffset: 3 * state.BlockSizeBytes,
+					SizeBytes:  2 * state.BlockSizeBytes,
+				},
+				createBlockMetadata(50, state.BlockSizeBytes),
+				createBlockMetadata(70, state.BlockSizeBytes),
+			},
+		},
+		{
+			// Comparing against zeros cannot find block 50,
+			// which was written with zeros.
+			name:     "zero scan fallback",
+			zeroScan: true,
+			expectedResponse: []*csi.BlockMetadata{
+				createBlockMetadata(3, state.BlockSizeBytes),
+				createBlockMetadata(4, state.BlockSizeBytes),
+				createBlockMetadata(70, state.BlockSizeBytes),
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			file, err := os.CreateTemp(t.TempDir(), "test-*.img")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer file.Close()
+			if err := file.Truncate(100 * state.BlockSizeBytes); err != nil {
+				t.Fatal(err)
+			}
+			modifyBlock(t, file, 3, []byte("data"))
+			modifyBlock(t, file, 4, []byte("data"))
+			modifyBlock(t, file, 50, nil)
+			modifyBlock(t, file, 70, []byte("data"))
+			if err := file.Sync(); err != nil {
+				t.Fatal(err)
+			}
+
+			br, err := newFileBlockReader("", file.Name(), 0, state.BlockSizeBytes, tc.blockMetadataType, 2)
+			if err != nil {
+				t.Fatalf("expected no error, got: %v", err)
+			}
+			defer br.Close()
+			br.zeroScan = tc.zeroScan
+
+			response := []*csi.BlockMetadata{}
+			for {
+				cb, cbErr := br.getChangedBlockMetadata(context.Background())
+				if cbErr != nil && cbErr != io.EOF {
+					t.Fatalf("expected no error, got: %v", cbErr)
+				}
+				response = append(response, cb...)
+				if cbErr == io.EOF {
+					break
+				}
+			}
+			if len(tc.expectedResponse) != len(response) {
+				t.Fatalf("expected %d allocated blocks metadata, got: %v", len(tc.expectedResponse), response)
+			}
+			for i := range response {
+				if response[i].String() != tc.expectedResponse[i].String() {
+					t.Fatalf("received unexpected block metadata, expected: %s\n, got %s", tc.expectedResponse[i].String(), response[i].String())
+				}
+			}
+		})
+	}
+}
+
+func TestSnapshotMetadataFromParameters(t *testing.T) {
+	testCases := []struct {
+		name                      string
+		params                    map[string]string
+		expectedBlockSize         int64
+		expectedBlockMetadataType string
+		expectErr                 bool
+	}{
+		{
+			name: "no parameters",
+		},
+		{
+			name: "block size in bytes",
+			params: map[string]string{
+				blockSizeParameterName: "65536",
+			},
+			expectedBlockSize: 64 * 1024,
+		},
+		{
+			name: "block size as quantity",
+			params: map[string]string{
+				blockSizeParameterName:         "1Mi",
+				blockMetadataTypeParameterName: "VARIABLE_LENGTH",
+			},
+			expectedBlockSize:         1024 * 1024,
+			expectedBlockMetadataType: "VARIABLE_LENGTH",
+		},
+		{
+			name: "block size not a power of two",
+			params: map[string]string{
+				blockSizeParameterName: "6000",
+			},
+			expectErr: true,
+		},
+		{
+			name: "block size too small",
+			params: map[string]string{
+				blockSizeParameterName: "256",
+			},
+			expectErr: true,
+		},
+		{
+			name: "invalid block size",
+			params: map[string]string{
+				blockSizeParameterName: "large",
+			},
+			expectErr: true,
+		},
+		{
+			name: "invalid block metadata type",
+			params: map[string]string{
+				blockMetadataTypeParameterName: "UNKNOWN",
+			},
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			blockSize, blockMetadataType, err := snapshotMetadataFromParameters(tc.params)
+			if tc.expectErr {
+				if err == nil {
+					t.Fatal("expected error, got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error, got: %v", err)
+			}
+			if blockSize != tc.expectedBlockSize {
+				t.Fatalf("expected block size %d, got %d", tc.expectedBlockSize, blockSize)
+			}
+			if blockMetadataType != tc.expectedBlockMetadataType {
+				t.Fatalf("expected block metadata type %q, got %q", tc.expectedBlockMetadataType, blockMetadataType)
+			}
+		})
+	}
+}
+
+// collectBlockMetadata returns all pages of block metadata of the reader.
+func collectBlockMetadata(t testing.TB, br *fileBlockReader) [][]*csi.BlockMetadata {
+	var pages [][]*csi.BlockMetadata
+	for {
+		cb, cbErr := br.getChangedBlockMetadata(context.Background())
+		if cbErr != nil && cbErr != io.EOF {
+			t.Fatalf("expected no error, got: %v", cbErr)
+		}
+		if len(cb) != 0 {
+			pages = append(pages, cb)
+		}
+		if cbErr == io.EOF {
+			return pages
+		}
+	}
+}
+
+func TestGetChangedBlockMetadataParallel(t *testing.T) {
+	testCases := []struct {
+		name              string
+		sourceFileBlocks  int
+		targetFileBlocks  int
+		noBase            bool
+		blockMetadataType csi.BlockMetadataType
+		startingOffset    int64
+		maxResult         int32
+	}{
+		{
+			name:             "same sizes",
+			sourceFileBlocks: 100,
+			targetFileBlocks: 100,
+			maxResult:        7,
+		},
+		{
+			name:              "variable length",
+			sourceFileBlocks:  100,
+			targetFileBlocks:  100,
+			blockMetadataType: csi.BlockMetadataType_VARIABLE_LENGTH,
+			maxResult:         3,
+		},
+		{
+			name:             "starting offset",
+			sourceFileBlocks: 100,
+			targetFileBlocks: 100,
+			startingOffset:   33 * state.BlockSizeBytes,
+			maxResult:        5,
+		},
+		{
+			name:             "larger target",
+			sourceFileBlocks: 80,
+			targetFileBlocks: 100,
+			maxResult:        10,
+		},
+		{
+			name:             "larger base",
+			sourceFileBlocks: 100,
+			targetFileBlocks: 80,
+			maxResult:        10,
+		},
+		{
+			name:             "no base",
+			targetFileBlocks: 100,
+			noBase:           true,
+			maxResult:        10,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sourceFile := createTempFile(t, tc.sourceFileBlocks)
+			defer sourceFile.Close()
+			targetFile := createTempFile(t, tc.targetFileBlocks)
+			defer targetFile.Close()
+			for i := 0; i < tc.targetFileBlocks; i += 3 {
+				modifyBlock(t, targetFile, i, []byte("changed block"))
+			}
+			for i := 40; i < 60; i++ {
+				modifyBlock(t, targetFile, i, nil)
+			}
+			baseName := sourceFile.Name()
+			if tc.noBase {
+				baseName = ""
+			}
+
+			var results [][][]*csi.BlockMetadata
+			for _, workers := range []int{1, 2, 5} {
+				br, err := newFileBlockReader(baseName, targetFile.Name(), tc.startingOffset, state.BlockSizeBytes, tc.blockMetadataType, tc.maxResult)
+				if err != nil {
+					t.Fatalf("expected no error, got: %v", err)
+				}
+				defer br.Close()
+				if err := br.seekToStartingOffset(); err != nil {
+					t.Fatalf("expected no error, got: %v", err)
+				}
+				br.zeroScan = true
+				br.workers = workers
+				// Small segments, so that the workers have to
+				// compare several of them.
+				br.segmentBytes = 8 * state.BlockSizeBytes
+				results = append(results, collectBlockMetadata(t, br))
+			}
+
+			if len(results[0]) == 0 {
+				t.Fatal("expected changed blocks, got none")
+			}
+			for i := 1; i < len(results); i++ {
+				if fmt.Sprint(results[0]) != fmt.Sprint(results[i]) {
+					t.Fatalf("parallel comparison differs from sequential comparison:\n%v\n%v", results[0], results[i])
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkGetChangedBlockMetadata compares the sequential and the parallel
+// comparison of two 64 MiB files in which every 16th block changed.
+func BenchmarkGetChangedBlockMetadata(b *testing.B) {
+	const blocks = 64 * 1024 * 1024 / state.BlockSizeBytes
+	dir := b.TempDir()
+	data := make([]byte, blocks*state.BlockSizeBytes)
+	base := filepath.Join(dir, "base")
+	if err := os.WriteFile(base, data, 0600); err != nil {
+		b.Fatal(err)
+	}
+	for i := 0; i < blocks; i += 16 {
+		data[i*state.BlockSizeBytes] = 1
+	}
+	target := filepath.Join(dir, "target")
+	if err := os.WriteFile(target, data, 0600); err != nil {
+		b.Fatal(err)
+	}
+
+	for _, workers := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			for b.Loop() {
+				br, err := newFileBlockReader(base, target, 0, state.BlockSizeBytes, csi.BlockMetadataType_FIXED_LENGTH, defaultMaxResults)
+				if err != nil {
+					b.Fatal(err)
+				}
+				br.workers = workers
+				collectBlockMetadata(b, br)
+				br.Close()
+			}
+		})
+	}
+}
@@ -61,12 +61,13 @@ func (hp *hostPath) GetMetadataAllocated(req *csi.GetMetadataAllocatedRequest, s
 		maxResults = defaultMaxResults
 	}
 
+	blockMetadataType := hp.snapshotBlockMetadataType(source)
 	br, err := newFileBlockReader(
 		"",
 		hp.getSnapshotPath(snapID),
 		req.StartingOffset,
-		state.BlockSizeBytes,
-		hp.config.SnapshotMetadataBlockType,
+		hp.snapshotBlockSize(source),
+		blockMetadataType,
 		maxResults,
 	)
 	if err != nil {
@@ -74,6 +75,8 @@ func (hp *hostPath) GetMetadataAllocated(req *csi.GetMetadataAllocatedRequest, s
 		return status.Error(codes.Internal, "failed initialize file block reader")
 	}
 	defer br.Close()
+	br.maxExtentLength = hp.config.SnapshotMetadataExtentLimit
+	br.workers = hp.config.SnapshotMetadataWorkers
 	if err := br.seekToStartingOffset(); err != nil {
 		return status.Error(codes.OutOfRange, fmt.Sprintf("failed to seek to starting offset: %v", err.Error()))
 	}
@@ -93,7 +96,7 @@ func (hp *hostPath) GetMetadataAllocated(req *csi.GetMetadataAllocatedRequest, s
 			if errors.Is(cbErr, io.EOF) {
 				klog.V(4).Info("reached EOF while getting allocated block metadata, returning")
 				// send allocated blocks found till EOF
-				if err := sendGetMetadataAllocatedResponse(stream, vol.VolSize, hp.config.SnapshotMetadataBlockType, cb); err != nil {
+				if err := sendGetMetadataAllocatedResponse(stream, vol.VolSize, blockMetadataType, cb); err != nil {
 					return err
 				}
 				return nil
@@ -102,7 +105,7 @@ func (hp *hostPath) GetMetadataAllocated(req *csi.GetMetadataAllocatedRequest, s
 			return status.Error(codes.Internal, "failed to get allocated block metadata")
 		}
 		// stream response to client
-		if err := sendGetMetadataAllocatedResponse(stream, vol.VolSize, hp.config.SnapshotMetadataBlockType, cb); err != nil {
+		if err := sendGetMetadataAllocatedResponse(stream, vol.VolSize, blockMetadataType, cb); err != nil {
 			return err
 		}
 	}
@@ -153,12 +156,35 @@ func (hp *hostPath) GetMetadataDelta(req *csi.GetMetadataDeltaRequest, stream cs
 		maxResults = defaultMaxResults
 	}
 
+	// Both block sizes are powers of two, so the larger one is a
+	// multiple of the smaller one.
+	blockSize := max(hp.snapshotBlockSize(source), hp.snapshotBlockSize(target))
+	blockMetadataType := hp.snapshotBlockMetadataType(target)
+	if bitmap, ok := hp.changedBlocksBetween(source, target, blockSize); ok {
+		klog.V(4).Infof("answering delta between snapshots %s and %s from changed block tracking", baseSnapID, targetSnapID)
+		offset := req.StartingOffset
+		for {
+			if err := ctx.Err(); err != nil {
+				klog.V(4).Infof("%v while getting changed block metadata, returning", err)
+				return nil
+			}
+			cb, nextOffset, cbErr := changedBlockMetadata(bitmap, offset, blockSize, hp.config.SnapshotMetadataExtentLimit, blockMetadataType, maxResults)
+			if err := sendGetMetadataDeltaResponse(stream, vol.VolSize, blockMetadataType, cb); err != nil {
+				return err
+			}
+			if cbErr != nil {
+				return nil
+			}
+			offset = nextOffset
+		}
+	}
+
 	br, err := newFileBlockReader(
 		hp.getSnapshotPath(baseSnapID),
 		hp.getSnapshotPath(targetSnapID),
 		req.StartingOffset,
-		state.BlockSizeBytes,
-		hp.config.SnapshotMetadataBlockType,
+		blockSize,
+		blockMetadataType,
 		maxResults,
 	)
 	if err != nil {
@@ -166,6 +192,8 @@ func (hp *hostPath) GetMetadataDelta(req *csi.GetMetadataDeltaRequest, stream cs
 		return status.Error(codes.Internal, "failed initialize file block reader")
 	}
 	defer br.Close()
+	br.maxExtentLength = hp.config.SnapshotMetadataExtentLimit
+	br.workers = hp.config.SnapshotMetadataWorkers
 	if err := br.seekToStartingOffset(); err != nil {
 		return status.Error(codes.OutOfRange, fmt.Sprintf("failed to seek to starting offset: %v", err.Error()))
 	}
@@ -185,7 +213,7 @@ func (hp *hostPath) GetMetadataDelta(req *csi.GetMetadataDeltaRequest, stream cs
 			if errors.Is(cbErr, io.EOF) {
 				klog.V(4).Info("reached EOF while getting changed block metadata, returning")
 				// send changed blocks found till EOF
-				if err := sendGetMetadataDeltaResponse(stream, vol.VolSize, hp.config.SnapshotMetadataBlockType, cb); err != nil {
+				if err := sendGetMetadataDeltaResponse(stream, vol.VolSize, blockMetadataType, cb); err != nil {
 					return err
 				}
 				return nil
@@ -194,12 +222,30 @@ func (hp *hostPath) GetMetadataDelta(req *csi.GetMetadataDeltaRequest, stream cs
 			return status.Error(codes.Internal, "failed to get changed block metadata")
 		}
 		// stream response to client
-		if err := sendGetMetadataDeltaResponse(stream, vol.VolSize, hp.config.SnapshotMetadataBlockType, cb); err != nil {
+		if err := sendGetMetadataDeltaResponse(stream, vol.VolSize, blockMetadataType, cb); err != nil {
 			return err
 		}
 	}
 }
 
+// snapshotBlockSize returns the block size of the snapshot metadata
+// service for the snapshot.
+func (hp *hostPath) snapshotBlockSize(snapshot state.Snapshot) int64 {
+	if snapshot.BlockSize > 0 {
+		return snapshot.BlockSize
+	}
+	return hp.config.SnapshotMetadataBlockSize
+}
+
+// snapshotBlockMetadataType returns the block metadata type of the
+// snapshot metadata service for the snapshot.
+func (hp *hostPath) snapshotBlockMetadataType(snapshot state.Snapshot) csi.BlockMetadataType {
+	if blockMetadataType, ok := csi.BlockMetadataType_value[snapshot.BlockMetadataType]; ok {
+		return csi.BlockMetadataType(blockMetadataType)
+	}
+	return hp.config.SnapshotMetadataBlockType
+}
+
 func sendGetMetadataDeltaResponse(
 	stream csi.SnapshotMetadata_GetMetadataDeltaServer,
 	volSize int64,
@@ -0,0 +1,230 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hostpath
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+	"unsafe"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"golang.org/x/sys/unix"
+	"k8s.io/klog/v2"
+
+	"github.com/kubernetes-csi/csi-driver-host-path/pkg/state"
+)
+
+// volumeUsage is the disk space and number of inodes used by the files
+// of a directory volume.
+type volumeUsage struct {
+	Bytes  int64
+	Inodes int64
+}
+
+type fileID struct {
+	dev, ino uint64
+}
+
+// walkUsage adds up the allocated blocks and the inodes of all files below
+// root, like du does. Files with more than one hard link are counted once.
+func walkUsage(root string) (volumeUsage, error) {
+	var usage volumeUsage
+	seen := map[fileID]bool{}
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		var st unix.Stat_t
+		if err := unix.Lstat(path, &st); err != nil {
+			return err
+		}
+		if !d.IsDir() && st.Nlink > 1 {
+			id := fileID{dev: uint64(st.Dev), ino: st.Ino}
+			if seen[id] {
+				return nil
+			}
+			seen[id] = true
+		}
+		// st_blocks is always in units of 512 bytes.
+		usage.Bytes += st.Blocks * 512
+		usage.Inodes++
+		return nil
+	})
+	return usage, err
+}
+
+type cachedUsage struct {
+	usage volumeUsage
+	time  time.Time
+}
+
+// usageCache remembers the usage of directory volumes, and of directories
+// which belong to them like extractions of snapshots, because walking
+// them is expensive. Volumes are keyed by ID, other directories by path.
+// It has its own mutex, which may be locked while holding hp.mutex.
+type usageCache struct {
+	mutex   sync.Mutex
+	volumes map[string]cachedUsage
+}
+
+func newUsageCache() *usageCache {
+	return &usageCache{
+		volumes: map[string]cachedUsage{},
+	}
+}
+
+func (c *usageCache) get(volID string, maxAge time.Duration, now time.Time) (volumeUsage, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	cached, ok := c.volumes[volID]
+	if !ok || now.Sub(cached.time) > maxAge {
+		return volumeUsage{}, false
+	}
+	return cached.usage, true
+}
+
+func (c *usageCache) set(volID string, usage volumeUsage, now time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.volumes[volID] = cachedUsage{usage: usage, time: now}
+}
+
+func (c *usageCache) forget(volID string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	delete(c.volumes, volID)
+}
+
+// getDirectoryUsage returns the usage of a directory volume, walking it
+// only if the cached usage is older than VolumeUsageMaxAge.
+func (hp *hostPath) getDirectoryUsage(vol state.Volume) (volumeUsage, error) {
+	now := time.Now()
+	if hp.config.VolumeUsageMaxAge > 0 {
+		if usage, ok := hp.usage.get(vol.VolID, hp.config.VolumeUsageMaxAge, now); ok {
+			return usage, nil
+		}
+	}
+	usage, err := walkUsage(vol.VolPath)
+	if err != nil {
+		return volumeUsage{}, fmt.Errorf("failed to get usage of volume %s: %w", vol.VolID, err)
+	}
+	klog.V(5).Infof("usage of volume %s: %d bytes, %d inodes", vol.VolID, usage.Bytes, usage.Inodes)
+	if hp.config.VolumeUsageMaxAge > 0 {
+		hp.usage.set(vol.VolID, usage, now)
+	}
+	return usage, nil
+}
+
+// getPathUsage returns the allocated bytes of a directory which belongs
+// to volumes without being a volume itself, like the extraction of a
+// snapshot. It is cached with the path as key.
+func (hp *hostPath) getPathUsage(path string) (int64, error) {
+	now := time.Now()
+	if hp.config.VolumeUsageMaxAge > 0 {
+		if usage, ok := hp.usage.get(path, hp.config.VolumeUsageMaxAge, now); ok {
+			return usage.Bytes, nil
+		}
+	}
+	usage, err := walkUsage(path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get usage of %s: %w", path, err)
+	}
+	klog.V(5).Infof("usage of %s: %d bytes, %d inodes", path, usage.Bytes, usage.Inodes)
+	if hp.config.VolumeUsageMaxAge > 0 {
+		hp.usage.set(path, usage, now)
+	}
+	return usage.Bytes, nil
+}
+
+// getDirectoryVolumeStats reports the usage of a directory volume relative
+// to its size instead of the usage of the whole filesystem which contains
+// it. The inodes are limited by the free inodes of that filesystem.
+func (hp *hostPath) getDirectoryVolumeStats(vol state.Volume) ([]*csi.VolumeUsage, error) {
+	usage, err := hp.getDirectoryUsage(vol)
+	if err != nil {
+		return nil, err
+	}
+	_, _, _, _, inodesFree, _, err := getPVStats(vol.VolPath)
+	if err != nil {
+		return nil, err
+	}
+	return []*csi.VolumeUsage{
+		{
+			Available: max(vol.VolSize-usage.Bytes, 0),
+			Used:      usage.Bytes,
+			Total:     vol.VolSize,
+			Unit:      csi.VolumeUsage_BYTES,
+		}, {
+			Available: inodesFree,
+			Used:      usage.Inodes,
+			Total:     usage.Inodes + inodesFree,
+			Unit:      csi.VolumeUsage_INODES,
+		},
+	}, nil
+}
+
+// getBlockDeviceSize returns the size of the block device at path. Tests
+// replace it.
+var getBlockDeviceSize = func(path string) (int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if info.Mode()&os.ModeDevice == 0 || info.Mode()&os.ModeCharDevice != 0 {
+		return 0, fmt.Errorf("%s is not a block device", path)
+	}
+	var size uint64
+	if _, _, errno := unix.Syscall(unix.SYS_IOCTL, file.Fd(), unix.BLKGETSIZE64, uintptr(unsafe.Pointer(&size))); errno != 0 {
+		return 0, fmt.Errorf("failed to get the size of %s: %w", path, errno)
+	}
+	return int64(size), nil
+}
+
+// getBlockVolumeStats reports the size of the device at which a block
+// volume is published and the space allocated for its backing file.
+// That is less than the size for sparse backing files and the whole
+// size for preallocated ones. Block volumes have no inodes.
+func getBlockVolumeStats(vol state.Volume, devicePath string) ([]*csi.VolumeUsage, error) {
+	size, err := getBlockDeviceSize(devicePath)
+	if err != nil {
+		return nil, err
+	}
+	used, err := allocatedBytes(vol.VolPath)
+	if err != nil {
+		return nil, err
+	}
+	return []*csi.VolumeUsage{
+		{
+			Available: max(size-used, 0),
+			Used:      used,
+			Total:     size,
+			Unit:      csi.VolumeUsage_BYTES,
+		},
+	}, nil
+}
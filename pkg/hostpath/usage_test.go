@@ -0,0 +1,169 @@
+/*
+Copyright 2026 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hostpath
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kubernetes-csi/csi-driver-host-path/pkg/state"
+)
+
+func TestWalkUsage(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(root, "dir"), 0750))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "dir", "file"), bytes.Repeat([]byte("x"), 64*1024), 0600))
+	empty, err := walkUsage(root)
+	require.NoError(t, err)
+
+	require.NoError(t, os.Link(filepath.Join(root, "dir", "file"), filepath.Join(root, "link")))
+	require.NoError(t, os.Symlink("dir/file", filepath.Join(root, "symlink")))
+	usage, err := walkUsage(root)
+	require.NoError(t, err)
+
+	assert.GreaterOrEqual(t, empty.Bytes, int64(64*1024), "file must be counted")
+	assert.Equal(t, int64(3), empty.Inodes, "root, directory and file")
+	assert.Less(t, usage.Bytes, 2*empty.Bytes, "hard link must not be counted twice")
+	assert.Equal(t, int64(4), usage.Inodes, "symlink must be counted, hard link not")
+}
+
+func TestDirectoryVolumeStats(t *testing.T) {
+	cfg := Config{
+		StateDir:          t.TempDir(),
+		Endpoint:          "unix://tmp/csi.sock",
+		DriverName:        "hostpath.csi.k8s.io",
+		NodeID:            "fakeNodeID",
+		VolumeUsageMaxAge: time.Hour,
+	}
+	hp, err := NewHostPathDriver(cfg)
+	require.NoError(t, err)
+
+	volPath := hp.getVolumePath("vol")
+	require.NoError(t, os.Mkdir(volPath, 0750))
+	require.NoError(t, os.WriteFile(filepath.Join(volPath, "file"), bytes.Repeat([]byte("x"), 64*1024), 0600))
+	require.NoError(t, hp.state.UpdateVolume(state.Volume{
+		VolID:         "vol",
+		VolName:       "vol-name",
+		VolSize:       1024 * 1024,
+		VolPath:       volPath,
+		VolAccessType: state.MountAccess,
+	}))
+	getStats := func() *csi.NodeGetVolumeStatsResponse {
+		resp, err := hp.NodeGetVolumeStats(context.Background(), &csi.NodeGetVolumeStatsRequest{VolumeId: "vol", VolumePath: volPath})
+		require.NoError(t, err)
+		return resp
+	}
+
+	resp := getStats()
+	assert.False(t, resp.VolumeCondition.Abnormal, "unexpected condition: %s", resp.VolumeCondition.Message)
+	require.Len(t, resp.Usage, 2)
+	used := resp.Usage[0].Used
+	assert.GreaterOrEqual(t, used, int64(64*1024))
+	assert.Equal(t, int64(1024*1024), resp.Usage[0].Total)
+	assert.Equal(t, 1024*1024-used, resp.Usage[0].Available)
+	assert.Equal(t, int64(2), resp.Usage[1].Used)
+	assert.Equal(t, resp.Usage[1].Used+resp.Usage[1].Available, resp.Usage[1].Total)
+
+	require.NoError(t, os.WriteFile(filepath.Join(volPath, "big"), bytes.Repeat([]byte("x"), 2*1024*1024), 0600))
+	resp = getStats()
+	assert.Equal(t, used, resp.Usage[0].Used, "usage must be served from the cache")
+
+	hp.usage.forget("vol")
+	resp = getStats()
+	assert.Greater(t, resp.Usage[0].Used, int64(2*1024*1024))
+	assert.Equal(t, int64(0), resp.Usage[0].Available)
+	assert.True(t, resp.VolumeCondition.Abnormal, "volume exceeding its size must be abnormal")
+	assert.Contains(t, resp.VolumeCondition.Message, "more than its size of 1048576 bytes")
+}
+
+func TestBlockVolumeStatsWithoutDevice(t *testing.T) {
+	backingFile := filepath.Join(t.TempDir(), "vol")
+	require.NoError(t, os.WriteFile(backingFile, make([]byte, 4096), 0600))
+	vol := state.Volume{VolID: "vol", VolPath: backingFile, VolSize: 4096, VolAccessType: state.BlockAccess}
+
+	_, err := getBlockVolumeStats(vol, backingFile)
+	assert.ErrorContains(t, err, "is not a block device")
+	_, err = getBlockVolumeStats(vol, "/dev/null")
+	assert.ErrorContains(t, err, "is not a block device")
+}
+
+func TestBlockVolumeStats(t *testing.T) {
+	dir := t.TempDir()
+	testCases := []struct {
+		name    string
+		thin    bool
+		written int64
+	}{
+		{
+			name:    "sparse",
+			thin:    true,
+			written: 64 * 1024,
+		},
+		{
+			name: "empty sparse",
+			thin: true,
+		},
+		{
+			name:    "preallocated",
+			written: 64 * 1024,
+		},
+	}
+
+	defer func(orig func(string) (int64, error)) { getBlockDeviceSize = orig }(getBlockDeviceSize)
+	getBlockDeviceSize = func(path string) (int64, error) {
+		assert.Equal(t, "/dev/loop-hostpath-test", path)
+		return mib, nil
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			backingFile := filepath.Join(dir, tc.name)
+			require.NoError(t, createBlockFile(backingFile, mib, tc.thin))
+			if tc.written > 0 {
+				file, err := os.OpenFile(backingFile, os.O_WRONLY, 0)
+				require.NoError(t, err)
+				_, err = file.WriteAt(bytes.Repeat([]byte("x"), int(tc.written)), 128*1024)
+				require.NoError(t, err)
+				require.NoError(t, file.Close())
+			}
+			allocated, err := allocatedBytes(backingFile)
+			require.NoError(t, err)
+			vol := state.Volume{VolID: "vol", VolPath: backingFile, VolSize: mib, VolAccessType: state.BlockAccess}
+
+			usage, err := getBlockVolumeStats(vol, "/dev/loop-hostpath-test")
+			require.NoError(t, err)
+			require.Len(t, usage, 1)
+			if tc.thin {
+				assert.GreaterOrEqual(t, usage[0].Used, tc.written, "sparse file must include written data")
+				assert.Less(t, usage[0].Used, mib, "sparse file must not count holes")
+			} else {
+				assert.GreaterOrEqual(t, usage[0].Used, mib, "preallocated file must count all blocks")
+			}
+			assert.Equal(t, allocated, usage[0].Used)
+			assert.Equal(t, mib, usage[0].Total)
+			assert.Equal(t, max(mib-usage[0].Used, 0), usage[0].Available)
+			assert.Equal(t, csi.VolumeUsage_BYTES, usage[0].Unit)
+		})
+	}
+}
@@ -21,6 +21,7 @@ package state
 import (
 	"encoding/json"
 	"errors"
+	"maps"
 	"os"
 	"sort"
 
@@ -59,6 +60,16 @@ type Volume struct {
 	// Published contains the target paths where the volume
 	// was published.
 	Published Strings
+	// OverlayLowerDir is the shared, read-only lower layer
+	// if the volume is an overlayfs clone of a snapshot.
+	OverlayLowerDir string
+	// SharedSnapshotDir is the extraction of a snapshot which
+	// is bind-mounted read-only at VolPath if the volume is
+	// a read-only restore of that snapshot.
+	SharedSnapshotDir string
+	// GroupSnapshotID is set if the volume was restored as part of
+	// restoring the group snapshot with that ID.
+	GroupSnapshotID string
 }
 
 type Snapshot struct {
@@ -70,6 +81,32 @@ type Snapshot struct {
 	SizeBytes       int64
 	ReadyToUse      bool
 	GroupSnapshotID string
+	// Checksum is the hex-encoded SHA-256 checksum of the snapshot
+	// file, computed when the snapshot was created. Empty for
+	// snapshots created by older driver releases.
+	Checksum string
+	// Corrupted is set when the snapshot file no longer matches
+	// its checksum. Such a snapshot is never ready to use again.
+	Corrupted bool
+	// PreviousSnapshotID is the snapshot of the same block volume
+	// which ChangedBlocksPath is relative to. Consecutive snapshots
+	// form a chain through it.
+	PreviousSnapshotID string
+	// ChangedBlocksPath is the file with the bitmap of the blocks
+	// which changed since the previous snapshot, one bit per block
+	// of ChangedBlockSize bytes.
+	ChangedBlocksPath string
+	ChangedBlockSize  int64
+	// BlockSize and BlockMetadataType are used by the snapshot
+	// metadata service for this snapshot. They come from the snapshot
+	// class. Zero and empty mean the driver defaults.
+	BlockSize         int64
+	BlockMetadataType string
+	// Kind is the storage kind of the source volume. DiskUsage is
+	// the space allocated for the snapshot file, which gets charged
+	// against the capacity of that kind.
+	Kind      string
+	DiskUsage int64
 }
 
 type GroupSnapshot struct {
@@ -79,6 +116,16 @@ type GroupSnapshot struct {
 	SourceVolumeIDs []string
 	CreationTime    *timestamppb.Timestamp
 	ReadyToUse      bool
+	// FreezeStartTime and FreezeEndTime record the window during
+	// which the source volumes were quiesced. They are unset if
+	// none of them needed to be quiesced.
+	FreezeStartTime *timestamppb.Timestamp
+	FreezeEndTime   *timestamppb.Timestamp
+	// Parameters are the parameters of the volume group snapshot
+	// class that the group snapshot was created with. They are nil
+	// for group snapshots which were created before parameters
+	// were persisted and empty if there were none.
+	Parameters map[string]string
 }
 
 // State is the interface that the rest of the code has to use to
@@ -348,6 +395,15 @@ func (gs *GroupSnapshot) MatchesSnapshotIDs(snapshotIDs []string) bool {
 	return equalIDs(gs.SnapshotIDs, snapshotIDs)
 }
 
+// MatchesParameters compares the parameters of the group snapshot. Unknown
+// parameters match anything.
+func (gs *GroupSnapshot) MatchesParameters(parameters map[string]string) bool {
+	if gs.Parameters == nil {
+		return true
+	}
+	return maps.Equal(gs.Parameters, parameters)
+}
+
 func equalIDs(a, b []string) bool {
 	if len(a) != len(b) {
 		return false
@@ -155,3 +155,33 @@ func TestVolumeGroupSnapshots(t *testing.T) {
 
 	require.Empty(t, s.GetGroupSnapshots(), "final groupsnapshots")
 }
+
+func TestGroupSnapshotParameters(t *testing.T) {
+	statefileName := path.Join(t.TempDir(), "state.json")
+	s, err := New(statefileName)
+	require.NoError(t, err, "construct state")
+	require.NoError(t, s.UpdateGroupSnapshot(GroupSnapshot{Id: "unknown", Name: "unknown"}))
+	require.NoError(t, s.UpdateGroupSnapshot(GroupSnapshot{Id: "none", Name: "none", Parameters: map[string]string{}}))
+	require.NoError(t, s.UpdateGroupSnapshot(GroupSnapshot{Id: "some", Name: "some", Parameters: map[string]string{"a": "b"}}))
+
+	s, err = New(statefileName)
+	require.NoError(t, err, "reconstruct state")
+	testCases := []struct {
+		id         string
+		parameters map[string]string
+		expected   bool
+	}{
+		{id: "unknown", parameters: nil, expected: true},
+		{id: "unknown", parameters: map[string]string{"a": "b"}, expected: true},
+		{id: "none", parameters: nil, expected: true},
+		{id: "none", parameters: map[string]string{"a": "b"}, expected: false},
+		{id: "some", parameters: map[string]string{"a": "b"}, expected: true},
+		{id: "some", parameters: nil, expected: false},
+		{id: "some", parameters: map[string]string{"a": "c"}, expected: false},
+	}
+	for _, tc := range testCases {
+		groupSnapshot, err := s.GetGroupSnapshotByID(tc.id)
+		require.NoError(t, err)
+		require.Equal(t, tc.expected, groupSnapshot.MatchesParameters(tc.parameters), "%s with %v", tc.id, tc.parameters)
+	}
+}